@@ -1,9 +1,78 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	_ "time/tzdata"
+
 	"github.com/vladikamira/pihole-parental-control/internal/app"
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+	"github.com/vladikamira/pihole-parental-control/internal/pihole"
 )
 
 func main() {
-	app.NewApp().Run()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config path] [validate|uninstall]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "":
+	case "validate":
+		validate(*configPath, flag.Args()[1:])
+		return
+	case "uninstall":
+		uninstall(*configPath, flag.Args()[1:])
+		return
+	default:
+		fmt.Printf("Unknown command %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	a, err := app.NewApp(*configPath)
+	if err != nil {
+		fmt.Printf("Failed to start app: %v\n", err)
+		os.Exit(1)
+	}
+	a.Run()
+}
+
+// validate checks the config (file and environment) without starting the app.
+func validate(configPath string, args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.StringVar(&configPath, "config", configPath, "path to the YAML config file")
+	flags.Parse(args)
+
+	if _, err := config.Load(configPath); err != nil {
+		fmt.Printf("Config is invalid: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Config is valid")
+}
+
+// uninstall deletes every group, deny rule and client the service created in
+// Pi-hole. Stop the service first, or its next check blocks again.
+func uninstall(configPath string, args []string) {
+	flags := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	flags.StringVar(&configPath, "config", configPath, "path to the YAML config file")
+	flags.Parse(args)
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Printf("Config is invalid: %v\n", err)
+		os.Exit(1)
+	}
+	client := pihole.NewClient(cfg)
+	deleted, err := client.Uninstall(context.Background(), cfg.Services)
+	client.Logout(context.Background())
+	fmt.Printf("Deleted %d groups, domains and clients from Pi-hole\n", deleted)
+	if err != nil {
+		fmt.Printf("Failed to uninstall: %v\n", err)
+		os.Exit(1)
+	}
 }
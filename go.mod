@@ -1,3 +1,11 @@
 module github.com/vladikamira/pihole-parental-control
 
 go 1.25
+
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/mattn/go-sqlite3 v1.14.33
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.13.0 // indirect
@@ -3,14 +3,18 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/vladikamira/pihole-parental-control/internal/pihole"
 )
 
 func (a *App) StartServer() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/reset", a.handleReset)
 	mux.HandleFunc("/stats", a.handleStats)
+	mux.HandleFunc("/backfill", a.handleBackfill)
 
 	fmt.Printf("Starting API server on port %s\n", a.cfg.ApiPort)
 	go func() {
@@ -26,46 +30,96 @@ func (a *App) handleReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ip := r.URL.Query().Get("ip")
-	if ip == "" {
-		http.Error(w, "Missing ip parameter", http.StatusBadRequest)
+	// ip is kept for compatibility, client accepts MAC addresses and host names as well
+	query := r.URL.Query()
+	key := query.Get("client")
+	if key == "" {
+		key = query.Get("ip")
+	}
+	if key == "" && query.Get("person") == "" {
+		http.Error(w, "Missing client or person parameter", http.StatusBadRequest)
 		return
 	}
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	ctx, cancel := context.WithTimeout(r.Context(), piholeTimeout)
+	defer cancel()
 
-	var targetClient *Client
-	for _, client := range a.stats.Clients {
-		if client.IP == ip {
-			targetClient = client
-			break
-		}
-	}
-
-	if targetClient == nil {
+	a.updatePeople()
+	target := a.findAccount(key, query.Get("person"))
+	if target == nil {
 		http.Error(w, "Client not found in stats", http.StatusNotFound)
 		return
 	}
 
 	// Unblock in Pi-hole
-	fmt.Printf("API: Unblocking client %s\n", ip)
-	err := a.client.UnblockDomainsForClient(context.Background(), ip)
-	if err != nil {
-		fmt.Printf("API: Failed to unblock client %s: %v\n", ip, err)
-		// We still reset stats even if Pi-hole fails?
-		// Actually, if it fails to unblock, maybe we shouldn't reset stats so it tries again or remains blocked.
-		// But the user specifically asked to reset stats AND unblock.
-		// If unblock fails, the client is still blocked in Pi-hole.
-		http.Error(w, fmt.Sprintf("Failed to unblock in Pi-hole: %v", err), http.StatusInternalServerError)
-		return
+	fmt.Printf("API: Unblocking client %s\n", target)
+	for _, service := range a.cfg.Services {
+		for _, client := range target.devices() {
+			usage := client.usage(service.Name)
+			if !usage.Blocked {
+				continue
+			}
+			err := a.client.UnblockDomainsForClient(ctx, client.blockedAs(), service.Name)
+			if err != nil {
+				fmt.Printf("API: Failed to unblock %s for client %s: %v\n", service.Name, client, err)
+				// If unblock fails, the client is still blocked in Pi-hole, so keep the stats
+				http.Error(w, fmt.Sprintf("Failed to unblock in Pi-hole: %v", err), piholeStatus(err))
+				return
+			}
+			usage.Blocked = false
+			usage.BlockReason = ""
+		}
+		usage := target.budget().usage(service.Name)
+		usage.Blocked = false
+		usage.BlockReason = ""
 	}
 
-	// Reset stats
-	resetClientStats(targetClient)
-	targetClient.Blocked = false
+	// Reset stats, a person shares them with all of their devices
+	target.budget().reset()
+	for _, client := range target.devices() {
+		resetClientStats(client)
+		client.ResetAt = a.cfg.Now()
+	}
+	a.saveState()
+
+	fmt.Fprintf(w, "Successfully reset stats and unblocked client %s\n", target)
+}
 
-	fmt.Fprintf(w, "Successfully reset stats and unblocked client %s\n", ip)
+// piholeStatus returns the response status for a failed Pi-hole request.
+func piholeStatus(err error) int {
+	switch {
+	case errors.Is(err, pihole.ErrConflict):
+		// Changed at the same time, the caller may try again
+		return http.StatusConflict
+	case errors.Is(err, pihole.ErrAuth), errors.Is(err, pihole.ErrForbidden):
+		return http.StatusBadGateway
+	case errors.Is(err, pihole.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// findAccount returns the account of a client key or a person name. A client
+// of a person resolves to the person, who owns the budget. Callers must hold a.mu.
+func (a *App) findAccount(key, person string) account {
+	if key != "" {
+		client := clientByKey(&a.stats, key)
+		if client == nil {
+			return nil
+		}
+		if client.Person == "" {
+			return client
+		}
+		person = client.Person
+	}
+	for _, p := range a.stats.People {
+		if p.Name == person {
+			return p
+		}
+	}
+	return nil
 }
 
 func (a *App) handleStats(w http.ResponseWriter, r *http.Request) {
@@ -82,3 +136,25 @@ func (a *App) handleStats(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("API: Failed to encode stats: %v\n", err)
 	}
 }
+
+func (a *App) handleBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a.mu.Lock()
+	ctx, cancel := context.WithTimeout(a.ctx, piholeTimeout)
+	err := a.backfill(ctx)
+	cancel()
+	a.mu.Unlock()
+	if err != nil {
+		fmt.Printf("API: Failed to backfill usage: %v\n", err)
+		http.Error(w, fmt.Sprintf("Failed to backfill usage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Apply limits to the recounted usage right away
+	a.requestCheck()
+	fmt.Fprintln(w, "Successfully backfilled today's usage")
+}
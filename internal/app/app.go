@@ -2,221 +2,783 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
 	"sort"
+	"syscall"
 	"time"
 
 	"github.com/vladikamira/pihole-parental-control/internal/config"
 	"github.com/vladikamira/pihole-parental-control/internal/pihole"
 	"github.com/vladikamira/pihole-parental-control/internal/speaker"
+	"github.com/vladikamira/pihole-parental-control/internal/store"
 	"github.com/vladikamira/pihole-parental-control/internal/telegram"
 )
 
-func NewApp() *App {
-	cfg := config.NewConfig()
+func NewApp(configPath string) (*App, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
 	client := pihole.NewClient(cfg)
 	tgClient := telegram.NewClient(cfg)
 	speakerClient := speaker.NewClient(cfg)
 
+	stateStore, err := store.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store: %w", err)
+	}
+
 	stats := DomainStats{
-		Domains:     cfg.DomainsToCheck,
+		Day:         today(cfg.Now()),
+		Services:    serviceDomains(cfg),
 		GlobalCount: 0,
 	}
 
 	return &App{
 		cfg:           cfg,
+		configPath:    configPath,
+		ctx:           context.Background(),
+		reload:        make(chan struct{}, 1),
 		client:        client,
 		tgClient:      tgClient,
 		speakerClient: speakerClient,
+		store:         stateStore,
 		stats:         stats,
-	}
+	}, nil
 }
 
+// Run checks the clients until the process receives SIGINT or SIGTERM, which
+// cancels the Pi-hole requests in flight.
 func (a *App) Run() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	a.ctx = ctx
+
+	a.loadState()
+	a.catchUp()
 	a.StartServer()
+	a.watchConfig()
 	fmt.Printf("Starting app with config: %v\n", a.cfg)
 
 	for {
-		a.mu.Lock()
-		if err := checkDomains(a.client, &a.stats); err != nil {
-			fmt.Printf("Failed to check domains: %v\n", err)
-			a.mu.Unlock()
+		a.check()
+
+		a.mu.RLock()
+		interval := a.cfg.CheckInternal
+		a.mu.RUnlock()
+
+		select {
+		case <-time.After(interval):
+		case <-a.reload:
+			fmt.Println("Re-evaluating clients...")
+		case <-ctx.Done():
+			fmt.Println("Shutting down...")
+			a.shutdown()
+			return
+		}
+	}
+}
+
+// shutdown saves the state and ends the Pi-hole session.
+func (a *App) shutdown() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.saveState()
+	if err := a.store.Close(); err != nil {
+		fmt.Printf("Failed to close state store: %v\n", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.client.Logout(ctx); err != nil {
+		fmt.Printf("Failed to log out of Pi-hole: %v\n", err)
+	}
+}
+
+// requestCheck makes Run check the clients right away.
+func (a *App) requestCheck() {
+	select {
+	case a.reload <- struct{}{}:
+	default:
+	}
+}
+
+// piholeTimeout bounds the Pi-hole requests of one check, backfill or reload.
+// They hold a.mu, which /stats and /reset wait for, so a Pi-hole that keeps
+// failing must not hold it for minutes of retries. What is left when the
+// deadline passes is caught up on the next check.
+const piholeTimeout = 30 * time.Second
+
+// check runs a single pass: fetches new queries and applies limits and curfews.
+func (a *App) check() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(a.ctx, piholeTimeout)
+	defer cancel()
+
+	if a.stats.Day != today(a.cfg.Now()) {
+		a.startNewDay(ctx)
+	}
+	a.refreshDevices(ctx)
+
+	// Queries fetched before a failure are counted, the rest is caught up
+	// on the next pass
+	if err := checkDomains(ctx, a.client, a.cfg, &a.stats, a.devices); err != nil {
+		fmt.Printf("Failed to check domains: %v\n", err)
+	}
+
+	a.mergeAddresses(ctx)
+	printStats(&a.stats)
+
+	a.registerProfileClients()
+	a.updatePeople()
+	for _, client := range a.stats.Clients {
+		a.moveBlocks(ctx, client)
+	}
+	for _, person := range a.stats.People {
+		a.syncBlocks(ctx, person)
+	}
+	for _, acct := range a.stats.accounts() {
+		profile, ok := acct.profile(a.cfg)
+		if !ok || !profile.Enforce {
+			// The client may have lost its profile or enforcement on reload
+			a.liftBlocks(ctx, acct)
 			continue
 		}
+		budget := acct.budget()
+		budget.Profile = profile.Name
+		for _, service := range a.cfg.Services {
+			usage := budget.usage(service.Name)
+			usage.Limit, usage.LimitRule = profile.ScheduleFor(service.Name).LimitFor(a.cfg.Now())
+		}
+		a.enforceCurfew(ctx, acct, profile)
+		for _, service := range a.cfg.Services {
+			a.enforceLimit(ctx, acct, service, profile)
+		}
+	}
+	a.liftRemovedServices(ctx, a.cfg)
+	a.repairBlocks(ctx)
+	a.reportDown()
+	a.saveState()
+}
 
-		printStats(&a.stats)
+// enforceCurfew blocks every service of the account outside of the allowed
+// windows and unblocks them when a window opens. Callers must hold a.mu.
+func (a *App) enforceCurfew(ctx context.Context, acct account, profile config.Profile) {
+	budget := acct.budget()
+	now := a.cfg.Now()
+	allowed, closes := config.AllowedAt(profile.AllowedWindows, now)
 
-		// Block clients that exceeded the limit
-		for _, client := range a.stats.Clients {
-			remaining := a.cfg.DaylyWatchingLimit - client.TimeWatchedToday
-			if !client.Blocked && !client.NotifiedNearLimit && remaining <= 5*time.Minute && remaining > 0 {
-				fmt.Printf("Client %s has less than 5 minutes left. Sending notification...\n", client.IP)
-				a.tgClient.SendMessage(fmt.Sprintf("Client %s has less than 5 minutes left (%v)", client.IP, remaining.Round(time.Minute)))
-				err := a.speakerClient.Speak(a.cfg.NearLimitMessage)
-				if err != nil {
-					fmt.Printf("Failed to speak near limit message: %v\n", err)
-				}
-				client.NotifiedNearLimit = true
-			}
-
-			if !client.Blocked && client.TimeWatchedToday > a.cfg.DaylyWatchingLimit {
-				fmt.Printf("Client %s reached limit. Blocking...\n", client.IP)
-				err := a.client.BlockDomainsForClient(context.Background(), client.IP, a.cfg.DomainsToCheck)
-				if err != nil {
-					fmt.Printf("Failed to block client %s: %v\n", client.IP, err)
-					a.tgClient.SendMessage(fmt.Sprintf("Failed to block client %s: %v", client.IP, err))
-				} else {
-					client.Blocked = true
-					a.tgClient.SendMessage(fmt.Sprintf("Client %s reached limit %s and is now blocked", client.IP, a.cfg.DaylyWatchingLimit))
-					err := a.speakerClient.Speak(a.cfg.LimitReachedMessage)
-					if err != nil {
-						fmt.Printf("Failed to speak limit reached message: %v\n", err)
-					}
-				}
+	if !allowed {
+		blocked := false
+		for _, service := range a.cfg.Services {
+			usage := budget.usage(service.Name)
+			if usage.Blocked {
+				continue
 			}
-		}
-		a.mu.Unlock()
-
-		if time.Now().After(midnight()) && time.Now().Before(midnight().Add(a.cfg.CheckInternal)) {
-			a.mu.Lock()
-			// Unblock all clients
-			for _, client := range a.stats.Clients {
-				if client.Blocked {
-					fmt.Printf("Client %s is blocked. Unblocking...\n", client.IP)
-					err := a.client.UnblockDomainsForClient(context.Background(), client.IP)
-					if err != nil {
-						fmt.Printf("Failed to unblock client %s: %v\n", client.IP, err)
-						a.tgClient.SendMessage(fmt.Sprintf("Failed to unblock client %s: %v", client.IP, err))
-					} else {
-						client.Blocked = false
-					}
-				}
+			fmt.Printf("Client %s (%s) is outside allowed hours. Blocking %s...\n", acct, profile.Name, service.Name)
+			if err := a.block(ctx, acct, service, BlockReasonCurfew); err == nil {
+				blocked = true
 			}
-			resetStats(&a.stats)
-			a.mu.Unlock()
 		}
-		time.Sleep(a.cfg.CheckInternal)
+		if !blocked {
+			return
+		}
+		budget.NotifiedCurfew = false
+		a.tgClient.SendMessage(fmt.Sprintf("Client %s (%s) is outside allowed hours and is now blocked", acct, profile.Name))
+		err := a.speakerClient.Speak(renderMessage(profile.CurfewReachedMessage, newMessageData(acct, "", profile, 0)))
+		if err != nil {
+			fmt.Printf("Failed to speak curfew message: %v\n", err)
+		}
+		return
+	}
+
+	unblocked := false
+	for _, service := range a.cfg.Services {
+		usage := budget.usage(service.Name)
+		if !usage.Blocked || usage.BlockReason != BlockReasonCurfew {
+			continue
+		}
+		if usage.TimeWatchedToday > usage.Limit {
+			// The budget ran out while the curfew was active
+			usage.BlockReason = BlockReasonLimit
+			continue
+		}
+		fmt.Printf("Client %s (%s) is inside allowed hours again. Unblocking %s...\n", acct, profile.Name, service.Name)
+		if err := a.unblock(ctx, acct, service); err == nil {
+			unblocked = true
+		}
+	}
+	if unblocked {
+		a.tgClient.SendMessage(fmt.Sprintf("Client %s (%s) is inside allowed hours and is now unblocked", acct, profile.Name))
+	}
+
+	untilCurfew := closes.Sub(now)
+	if !budget.NotifiedCurfew && !closes.IsZero() && untilCurfew <= profile.CurfewWarning {
+		fmt.Printf("Client %s (%s) curfew starts in %v. Sending notification...\n", acct, profile.Name, untilCurfew.Round(time.Minute))
+		a.tgClient.SendMessage(fmt.Sprintf("Client %s (%s) curfew starts at %s", acct, profile.Name, closes.Format("15:04")))
+		err := a.speakerClient.Speak(renderMessage(profile.CurfewWarningMessage, newMessageData(acct, "", profile, untilCurfew)))
+		if err != nil {
+			fmt.Printf("Failed to speak curfew warning message: %v\n", err)
+		}
+		budget.NotifiedCurfew = true
 	}
 }
 
-func checkDomains(client *pihole.Client, stats *DomainStats) error {
+// enforceLimit notifies the account when the service limit is close and blocks
+// the service once the limit is exceeded. Callers must hold a.mu.
+func (a *App) enforceLimit(ctx context.Context, acct account, service config.Service, profile config.Profile) {
+	usage := acct.budget().usage(service.Name)
+	remaining := usage.Limit - usage.TimeWatchedToday
+	if !usage.Blocked && !usage.NotifiedNearLimit && remaining <= profile.WarningThreshold && remaining > 0 {
+		fmt.Printf("Client %s (%s) has less than %v of %s left. Sending notification...\n", acct, profile.Name, profile.WarningThreshold, service.Name)
+		a.tgClient.SendMessage(fmt.Sprintf("Client %s (%s) has less than %v of %s left (%v)", acct, profile.Name, profile.WarningThreshold, service.Name, remaining.Round(time.Minute)))
+		err := a.speakerClient.Speak(renderMessage(profile.NearLimitMessage, newMessageData(acct, service.Name, profile, remaining)))
+		if err != nil {
+			fmt.Printf("Failed to speak near limit message: %v\n", err)
+		}
+		usage.NotifiedNearLimit = true
+	}
 
-	for _, domain := range stats.Domains {
-		queryStats, err := client.GetDomainStats(context.Background(), domain)
+	if usage.Blocked && usage.BlockReason == BlockReasonLimit && usage.TimeWatchedToday <= usage.Limit {
+		// The limit was raised on reload
+		fmt.Printf("Client %s (%s) is within the new %s limit. Unblocking...\n", acct, profile.Name, service.Name)
+		if err := a.unblock(ctx, acct, service); err == nil {
+			a.tgClient.SendMessage(fmt.Sprintf("Client %s (%s) is within the new %s limit %s and is now unblocked", acct, profile.Name, service.Name, usage.Limit))
+		}
+		return
+	}
+
+	if !usage.Blocked && usage.TimeWatchedToday > usage.Limit {
+		fmt.Printf("Client %s (%s) reached %s limit. Blocking...\n", acct, profile.Name, service.Name)
+		if err := a.block(ctx, acct, service, BlockReasonLimit); err != nil {
+			return
+		}
+		a.tgClient.SendMessage(fmt.Sprintf("Client %s (%s) reached %s %s limit %s and is now blocked", acct, profile.Name, service.Name, usage.LimitRule, usage.Limit))
+		err := a.speakerClient.Speak(renderMessage(profile.LimitReachedMessage, newMessageData(acct, service.Name, profile, 0)))
 		if err != nil {
-			fmt.Printf("get domain stats failed: %v\n", err)
-			return fmt.Errorf("get domain stats failed: %v", err)
+			fmt.Printf("Failed to speak limit reached message: %v\n", err)
 		}
+	}
+}
 
-		stats.GlobalCount += len(queryStats.Queries)
+// block blocks the service domains for every device of the account in
+// Pi-hole. Devices that are blocked already are skipped, so a failed block
+// of a person is completed by the next call. Callers must hold a.mu.
+func (a *App) block(ctx context.Context, acct account, service config.Service, reason string) error {
+	for _, client := range acct.devices() {
+		usage := client.usage(service.Name)
+		if usage.Blocked {
+			continue
+		}
+		// Every block of a client goes to the same Pi-hole client, moveBlocks
+		// moves them together
+		blockedAs := client.blockedAs()
+		err := a.client.BlockDomainsForClient(ctx, blockedAs, service.Name, service.Patterns)
+		if err != nil {
+			fmt.Printf("Failed to block %s for client %s: %v\n", service.Name, client, err)
+			a.notifyFailure(fmt.Sprintf("Failed to block %s for client %s", service.Name, client), err)
+			return err
+		}
+		client.BlockedAs = blockedAs
+		usage.Blocked = true
+		usage.BlockReason = reason
+	}
+	usage := acct.budget().usage(service.Name)
+	usage.Blocked = true
+	usage.BlockReason = reason
+	return nil
+}
 
-		if len(queryStats.Queries) == 0 {
+// unblock removes the service block of every device of the account in
+// Pi-hole. Callers must hold a.mu.
+func (a *App) unblock(ctx context.Context, acct account, service config.Service) error {
+	for _, client := range acct.devices() {
+		usage := client.usage(service.Name)
+		if !usage.Blocked {
 			continue
 		}
+		err := a.client.UnblockDomainsForClient(ctx, client.blockedAs(), service.Name)
+		if err != nil {
+			fmt.Printf("Failed to unblock %s for client %s: %v\n", service.Name, client, err)
+			a.notifyFailure(fmt.Sprintf("Failed to unblock %s for client %s", service.Name, client), err)
+			return err
+		}
+		usage.Blocked = false
+		usage.BlockReason = ""
+	}
+	usage := acct.budget().usage(service.Name)
+	usage.Blocked = false
+	usage.BlockReason = ""
+	return nil
+}
+
+// notifyFailure reports a failed block or unblock via Telegram. Conflicts
+// with changes made in Pi-hole at the same time are retried on the next check
+// without a message, so are failures while Pi-hole is down, on shutdown and
+// past the deadline of the check.
+func (a *App) notifyFailure(msg string, err error) {
+	switch {
+	case errors.Is(err, pihole.ErrConflict), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return
+	case errors.Is(err, pihole.ErrUnavailable):
+		// Reported once by reportDown
+		return
+	case errors.Is(err, pihole.ErrAuth):
+		msg += ": Pi-hole rejected the password, check PIHOLE_PASSWORD"
+	case errors.Is(err, pihole.ErrForbidden):
+		msg += ": Pi-hole denied the change, allow application passwords to change settings (webserver.api.app_sudo)"
+	default:
+		msg = fmt.Sprintf("%s: %v", msg, err)
+	}
+	a.tgClient.SendMessage(msg)
+}
+
+// reportDown reports once when Pi-hole stops answering and once when it is
+// back, instead of every failed request. Callers must hold a.mu.
+func (a *App) reportDown() {
+	err := a.client.Down()
+	switch {
+	case err != nil && !a.piholeDown:
+		fmt.Printf("Pi-hole is down: %v\n", err)
+		a.tgClient.SendMessage(fmt.Sprintf("Pi-hole is down, blocks cannot be changed until it is back: %v", err))
+	case err == nil && a.piholeDown:
+		fmt.Println("Pi-hole is back")
+		a.tgClient.SendMessage("Pi-hole is back")
+	}
+	a.piholeDown = err != nil
+}
+
+// liftBlocks unblocks every service of an account that is no longer enforced.
+// Callers must hold a.mu.
+func (a *App) liftBlocks(ctx context.Context, acct account) {
+	for _, service := range a.cfg.Services {
+		if usage := acct.budget().usage(service.Name); usage.Blocked {
+			fmt.Printf("Client %s is no longer enforced. Unblocking %s...\n", acct, service.Name)
+			a.unblock(ctx, acct, service)
+		}
+	}
+}
+
+// startNewDay lifts limit blocks and resets the daily counters. Curfew blocks
+// are lifted by enforceCurfew once a window opens. Callers must hold a.mu.
+func (a *App) startNewDay(ctx context.Context) {
+	day := today(a.cfg.Now())
+	fmt.Printf("New day started (%s). Resetting stats...\n", day)
+	a.updatePeople()
+	for _, acct := range a.stats.accounts() {
+		// Outside the allowed windows limit blocks turn into curfew blocks,
+		// lifted once a window opens, instead of being lifted and blocked
+		// again by enforceCurfew with a message
+		curfew := false
+		if profile, ok := acct.profile(a.cfg); ok && profile.Enforce {
+			allowed, _ := config.AllowedAt(profile.AllowedWindows, a.cfg.Now())
+			curfew = !allowed
+		}
+		for _, service := range a.cfg.Services {
+			usage := acct.budget().usage(service.Name)
+			if !usage.Blocked || usage.BlockReason == BlockReasonCurfew {
+				continue
+			}
+			if curfew {
+				fmt.Printf("Client %s is outside allowed hours. Keeping %s blocked...\n", acct, service.Name)
+				setBlockReason(acct, service.Name, BlockReasonCurfew)
+				continue
+			}
+			fmt.Printf("Client %s is blocked for %s. Unblocking...\n", acct, service.Name)
+			a.unblock(ctx, acct, service)
+		}
+	}
+	resetStats(&a.stats, day)
+	a.cleanup(ctx)
+	a.saveState()
+}
+
+// setBlockReason changes why the service is blocked for the account and its
+// blocked devices.
+func setBlockReason(acct account, service, reason string) {
+	for _, client := range acct.devices() {
+		if usage := client.usage(service); usage.Blocked {
+			usage.BlockReason = reason
+		}
+	}
+	acct.budget().usage(service).BlockReason = reason
+}
+
+func (a *App) loadState() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var stats DomainStats
+	err := a.store.Load(&stats)
+	if errors.Is(err, store.ErrNotFound) {
+		fmt.Println("No saved state found. Starting with empty stats")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to load state: %v\n", err)
+		return
+	}
+
+	// Services always come from the current config
+	stats.Services = serviceDomains(a.cfg)
+	for _, client := range stats.Clients {
+		// State of older versions lacks the ID or the addresses
+		if client.ID == "" || len(client.Addresses) == 0 {
+			client.identify(client.identity())
+		}
+		if client.LastSeen.IsZero() {
+			client.LastSeen = time.Now()
+		}
+	}
+	a.stats = stats
+	fmt.Printf("Loaded state for %s with %d clients\n", stats.Day, len(stats.Clients))
+}
+
+// saveState persists the current stats. Callers must hold a.mu.
+func (a *App) saveState() {
+	if err := a.store.Save(&a.stats); err != nil {
+		fmt.Printf("Failed to save state: %v\n", err)
+	}
+}
+
+func serviceDomains(cfg config.Config) map[string][]string {
+	services := map[string][]string{}
+	for _, service := range cfg.Services {
+		for _, pattern := range service.Patterns {
+			services[service.Name] = append(services[service.Name], pattern.String())
+		}
+	}
+	return services
+}
+
+// checkDomains fetches and counts the new queries of every service. A service
+// whose queries cannot be fetched does not keep the others from being counted.
+func checkDomains(ctx context.Context, client *pihole.Client, cfg config.Config, stats *DomainStats, devices devices) error {
+	stats.QueryLog.Pages = 0
+	pruneCursors(cfg, stats)
+	var errs []error
+	for _, service := range cfg.Services {
+		now := cfg.Now()
+		queries, complete, err := serviceQueries(ctx, client, cfg, service, stats, now)
+		estimator := NewUsageEstimator(service.Usage)
+
+		stats.GlobalCount += len(queries)
 
 		// Sort queries by time to ensure chronological processing
-		sort.Slice(queryStats.Queries, func(i, j int) bool {
-			return queryStats.Queries[i].Time < queryStats.Queries[j].Time
+		sort.Slice(queries, func(i, j int) bool {
+			return queries[i].Time < queries[j].Time
 		})
 
-		for _, query := range queryStats.Queries {
+		clientQueries := map[*Client][]pihole.Query{}
+		for _, query := range queries {
+			id := devices.resolve(query.Client)
+			client := findClient(stats, id)
+			if client == nil {
+				// clients without a profile are not tracked at all
+				if _, ok := cfg.ProfileFor(id.MAC, id.Hostname, id.IP); !ok {
+					continue
+				}
+				client = NewClientStats(id)
+				stats.Clients = append(stats.Clients, client)
+			}
+			client.identify(id)
+			if t := queryTime(query); t.After(client.LastSeen) {
+				client.LastSeen = t
+			}
+			clientQueries[client] = append(clientQueries[client], query)
+		}
+		for _, client := range stats.Clients {
+			usage := client.usage(service.Name)
+			for _, query := range clientQueries[client] {
+				if usage.Statuses == nil {
+					usage.Statuses = map[string]int{}
+				}
+				usage.Statuses[query.Status]++
+			}
+		}
 
-			// check if client exist
-			if !checkIfClientExist(stats, query.Client.IP) {
-				stats.Clients = append(stats.Clients, NewClientStats(query.Client.IP))
+		// Without every query up to now, held back queries must keep waiting
+		decideUntil := complete
+		if err != nil {
+			decideUntil = time.Time{}
+		}
+
+		// Held back queries of clients without new queries may be due as well
+		for _, client := range stats.Clients {
+			usage := client.usage(service.Name)
+			for _, query := range filterNoise(service.Noise, &usage.Noise, clientQueries[client], decideUntil) {
+				// update client stats and register and check time interval
+				updateClientStats(client, service.Name, estimator, query.Domain, query.Time)
 			}
+		}
 
-			// update client stats and register and check time interval
-			qTime := time.Unix(int64(query.Time), 0)
-			updateClientStats(stats, query.Client.IP, qTime)
+		if err != nil {
+			fmt.Printf("get domain stats failed: %v\n", err)
+			errs = append(errs, fmt.Errorf("get domain stats failed for %s: %w", service.Name, err))
+			if errors.Is(err, pihole.ErrUnavailable) || ctx.Err() != nil {
+				// The other services would fail as well
+				break
+			}
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-func updateClientStats(stats *DomainStats, ip string, t time.Time) {
-	for _, client := range stats.Clients {
-		if client.IP == ip {
-			// Skip if we've already processed this query or newer ones
-			if !t.After(client.LastQueryTime) && !client.LastQueryTime.IsZero() {
-				return
-			}
-			client.LastQueryTime = t
-			client.RequestsToday++
-
-			if len(client.WatchIntervals) == 0 {
-				client.WatchIntervals = append(client.WatchIntervals, WatchIntervals{
-					Start:    t,
-					End:      t.Add(5 * time.Minute),
-					Requests: 1,
-				})
-				client.TimeWatchedToday += 5 * time.Minute
-				return
-			}
-
-			// check if last interval is active
-			lastInterval := &client.WatchIntervals[len(client.WatchIntervals)-1]
-			if t.After(lastInterval.Start) && t.Before(lastInterval.End) {
-				lastInterval.Requests++
-			} else {
-				// check diff with previous window
-				diff := t.Sub(lastInterval.End)
-				start := t
-				if diff < 5*time.Minute {
-					start = lastInterval.End
+// serviceQueries fetches the new queries of every service pattern since its
+// cursor. The query log filters are broader than some patterns, so only
+// queries for domains the service blocks are kept, each once. It returns the
+// time up to which every query is known: now, or earlier when a filter had
+// more queries than the page cap allows, in which case the newer queries of
+// every filter are left for the next check. When a filter fails, nothing is
+// returned and no cursor of the service moves: counting the other filters
+// would move the usage past the catch-up queries of the failed one.
+func serviceQueries(ctx context.Context, client *pihole.Client, cfg config.Config, service config.Service, stats *DomainStats, now time.Time) ([]pihole.Query, time.Time, error) {
+	type fetched struct {
+		cursor  *QueryCursor
+		queries []pihole.Query
+	}
+	var results []fetched
+	complete := now
+	for _, pattern := range service.Patterns {
+		for _, filter := range pattern.QueryFilters() {
+			// New filters start at midnight, usage of previous days does not
+			// matter anymore
+			cursor := stats.cursor(filter)
+			from := cursor.Time
+			if midnight := startOfDay(now); from.Before(midnight) {
+				from = midnight
+			}
+
+			queryStats, err := client.GetDomainStats(ctx, filter, from, now)
+			if err != nil {
+				return nil, now, err
+			}
+			stats.QueryLog.Pages += queryStats.Pages
+			stats.QueryLog.MaxPages = max(stats.QueryLog.MaxPages, queryStats.Pages)
+			if queryStats.Truncated {
+				stats.QueryLog.Truncated++
+				// Only the oldest queries were read. The newest second read
+				// may be incomplete, so it is fetched again with the rest.
+				var newest time.Time
+				for _, query := range queryStats.Queries {
+					if t := queryTime(query); t.After(newest) {
+						newest = t
+					}
+				}
+				if newest.Before(complete) {
+					complete = newest
 				}
+			}
+			results = append(results, fetched{cursor: cursor, queries: queryStats.Queries})
+		}
+	}
 
-				client.WatchIntervals = append(client.WatchIntervals, WatchIntervals{
-					Start:    start,
-					End:      start.Add(5 * time.Minute),
-					Requests: 1,
-				})
-				client.TimeWatchedToday += 5 * time.Minute
+	var queries []pihole.Query
+	seen := map[int]bool{}
+	limited := complete.Before(now)
+	for _, result := range results {
+		next := *result.cursor
+		for _, query := range result.queries {
+			if result.cursor.Seen(query) || (limited && !queryTime(query).Before(complete)) {
+				continue
+			}
+			next.Advance(query)
+			if seen[query.ID] {
+				continue
+			}
+			if _, ok := service.Match(query.Domain); !ok {
+				continue
 			}
+			seen[query.ID] = true
+			queries = append(queries, query)
 		}
+		// Every query logged before the last second has been seen, or before
+		// the queries left for the next check
+		settled := now.Add(-time.Second).Truncate(time.Second)
+		if limited {
+			settled = complete
+		}
+		if next.Time.Before(settled) {
+			next.Time = settled
+		}
+		*result.cursor = next
 	}
+	return queries, complete, nil
 }
 
-func NewClientStats(ip string) *Client {
-	return &Client{
-		RequestsToday:    0,
-		IP:               ip,
-		TimeWatchedToday: time.Duration(0),
+// cursor returns the query log cursor of a filter, creating it on first use.
+func (s *DomainStats) cursor(filter string) *QueryCursor {
+	if s.Cursors == nil {
+		s.Cursors = map[string]*QueryCursor{}
+	}
+	cursor, ok := s.Cursors[filter]
+	if !ok {
+		cursor = &QueryCursor{}
+		s.Cursors[filter] = cursor
 	}
+	return cursor
 }
 
-func checkIfClientExist(stats *DomainStats, ip string) bool {
-	for _, client := range stats.Clients {
-		if client.IP == ip {
-			return true
+// pruneCursors drops cursors of filters no service uses anymore.
+func pruneCursors(cfg config.Config, stats *DomainStats) {
+	used := map[string]bool{}
+	for _, service := range cfg.Services {
+		for _, pattern := range service.Patterns {
+			for _, filter := range pattern.QueryFilters() {
+				used[filter] = true
+			}
+		}
+	}
+	for filter := range stats.Cursors {
+		if !used[filter] {
+			delete(stats.Cursors, filter)
+		}
+	}
+}
+
+// Seen reports whether the query was processed before. Queries are fetched
+// from the second of the cursor, so queries of that second are compared by ID.
+func (c *QueryCursor) Seen(query pihole.Query) bool {
+	t := queryTime(query)
+	return t.Before(c.Time) || (t.Equal(c.Time) && query.ID <= c.LastID)
+}
+
+// Advance moves the cursor past the query.
+func (c *QueryCursor) Advance(query pihole.Query) {
+	t := queryTime(query)
+	if t.After(c.Time) || (t.Equal(c.Time) && query.ID > c.LastID) {
+		c.Time = t
+		c.LastID = query.ID
+	}
+}
+
+func queryTime(query pihole.Query) time.Time {
+	return time.Unix(int64(query.Time), 0)
+}
+
+func updateClientStats(client *Client, service string, estimator UsageEstimator, domain string, t time.Time) {
+	// Usage before a reset through the API stays forgiven
+	if t.Before(client.ResetAt) {
+		return
+	}
+	usage := client.usage(service)
+
+	// Skip queries older than the ones already processed. Queries of
+	// the same second are new, duplicates are dropped by ID before.
+	if t.Before(usage.LastQueryTime) {
+		return
+	}
+	usage.LastQueryTime = t
+	estimator.Record(usage, domain, t)
+}
+
+func NewClientStats(id Identity) *Client {
+	client := &Client{
+		Budget:   Budget{Services: map[string]*ServiceUsage{}},
+		LastSeen: time.Now(),
+	}
+	client.identify(id)
+	return client
+}
+
+// usage returns the usage of the service, creating it on first use.
+func (b *Budget) usage(service string) *ServiceUsage {
+	if b.Services == nil {
+		b.Services = map[string]*ServiceUsage{}
+	}
+	usage, ok := b.Services[service]
+	if !ok {
+		usage = &ServiceUsage{}
+		b.Services[service] = usage
+	}
+	return usage
+}
+
+// registerProfileClients adds clients with an explicit profile or owner to the stats
+// so curfews apply before their first query. Callers must hold a.mu.
+func (a *App) registerProfileClients() {
+	for _, key := range a.configuredClients() {
+		if clientByKey(&a.stats, key) == nil {
+			a.stats.Clients = append(a.stats.Clients, NewClientStats(identityFromKey(key)))
 		}
 	}
-	return false
+}
+
+// configuredClients returns the devices with an explicit profile or owner.
+func (a *App) configuredClients() []string {
+	var keys []string
+	for key, profile := range a.cfg.ClientProfiles {
+		if profile != config.NoProfile {
+			keys = append(keys, key)
+		}
+	}
+	for _, person := range a.cfg.People {
+		keys = append(keys, person.Devices...)
+	}
+	// Subnets are not a single device
+	return slices.DeleteFunc(keys, func(key string) bool {
+		_, err := netip.ParsePrefix(key)
+		return err == nil
+	})
 }
 
 func printStats(stats *DomainStats) {
 	for _, client := range stats.Clients {
-		fmt.Printf("Client %s timeWatched: %v Requests: %d\n", client.IP, client.TimeWatchedToday, client.RequestsToday)
-		for _, interval := range client.WatchIntervals {
-			fmt.Printf("  Interval Start: %s End: %s Requests: %d\n", interval.Start, interval.End, interval.Requests)
+		for name, usage := range client.Services {
+			fmt.Printf("Client %s %s timeWatched: %v Requests: %d\n", client, name, usage.TimeWatchedToday, usage.RequestsToday)
+			for _, interval := range usage.WatchIntervals {
+				fmt.Printf("  Interval Start: %s End: %s Requests: %d\n", interval.Start, interval.End, interval.Requests)
+			}
 		}
 	}
 }
 
-func midnight() time.Time {
-	return time.Date(time.Now().Year(), time.Now().Month(), time.Now().Day(), 0, 0, 0, 0, time.Now().Location())
+func today(now time.Time) string {
+	return now.Format("2006-01-02")
+}
+
+func startOfDay(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 }
 
-func resetStats(stats *DomainStats) {
+func resetStats(stats *DomainStats, day string) {
+	stats.Day = day
+	stats.QueryLog = QueryLogStats{}
 	for _, client := range stats.Clients {
 		resetClientStats(client)
 	}
+	for _, person := range stats.People {
+		person.reset()
+	}
 }
 
 func resetClientStats(client *Client) {
-	client.RequestsToday = 0
-	client.TimeWatchedToday = 0
-	client.WatchIntervals = nil
-	client.NotifiedNearLimit = false
+	client.reset()
+	client.ResetAt = time.Time{}
+	// Addresses of previous days may belong to other devices by now
+	client.Addresses = nil
+	if client.IP != "" {
+		client.Addresses = []string{client.IP}
+	}
+}
+
+// reset clears the daily usage. Blocks stay in place.
+func (b *Budget) reset() {
+	for _, usage := range b.Services {
+		usage.RequestsToday = 0
+		usage.TimeWatchedToday = 0
+		usage.WatchIntervals = nil
+		usage.NotifiedNearLimit = false
+		usage.Noise = NoiseState{}
+		usage.Statuses = nil
+	}
+	b.NotifiedCurfew = false
 }
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+	"github.com/vladikamira/pihole-parental-control/internal/domain"
+	"github.com/vladikamira/pihole-parental-control/internal/pihole"
+)
+
+// fakeQueryLog answers query log requests with the queries of the requested
+// filter. A filter in fails fails that many times first.
+type fakeQueryLog struct {
+	queries map[string][]pihole.Query
+	fails   map[string]int
+}
+
+func (f *fakeQueryLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/auth" {
+		w.Write([]byte(`{"session":{"valid":true,"sid":"sid","validity":300}}`))
+		return
+	}
+	filter := r.URL.Query().Get("domain")
+	if f.fails[filter] > 0 {
+		f.fails[filter]--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	queries := f.queries[filter]
+	json.NewEncoder(w).Encode(pihole.QueryStats{Queries: queries, RecordsTotal: len(queries), RecordsFiltered: len(queries)})
+}
+
+func TestServiceQueriesKeepsCursorsOnError(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	fake := &fakeQueryLog{
+		queries: map[string][]pihole.Query{
+			"www.youtube.com": {{ID: 1, Time: float64(now.Add(-30 * time.Second).Unix()), Domain: "www.youtube.com"}},
+			"*.ytimg.com":     {{ID: 2, Time: float64(now.Add(-20 * time.Second).Unix()), Domain: "i.ytimg.com"}},
+		},
+		// The second filter fails once, after the first was fetched
+		fails: map[string]int{"*.ytimg.com": 1},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cfg := config.Config{PiholeAddress: server.URL, Password: "pw", QueryPageSize: 100, MaxQueryPages: 5, RetryBackoff: 1, Location: time.UTC}
+	client := pihole.NewClient(cfg)
+	service := config.Service{
+		Name:     "youtube",
+		Patterns: []domain.Pattern{mustParse(t, "full:www.youtube.com"), mustParse(t, "*.ytimg.com")},
+	}
+	stats := &DomainStats{}
+
+	ids := func(queries []pihole.Query) []int {
+		var ids []int
+		for _, query := range queries {
+			ids = append(ids, query.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	queries, _, err := serviceQueries(context.Background(), client, cfg, service, stats, now)
+	if err == nil {
+		t.Fatal("first check succeeded, want the error of the failing filter")
+	}
+	if len(queries) != 0 {
+		t.Errorf("first check returned queries %v, want none", ids(queries))
+	}
+	for filter, cursor := range stats.Cursors {
+		if !cursor.Time.IsZero() {
+			t.Errorf("cursor of %s moved to %v", filter, cursor.Time)
+		}
+	}
+
+	// The next check finds the queries of both filters
+	queries, _, err = serviceQueries(context.Background(), client, cfg, service, stats, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(queries); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("second check returned queries %v, want [1 2]", got)
+	}
+
+	queries, _, err = serviceQueries(context.Background(), client, cfg, service, stats, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 0 {
+		t.Errorf("third check returned queries %v again, want none", ids(queries))
+	}
+}
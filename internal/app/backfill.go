@@ -0,0 +1,178 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+)
+
+// catchUp brings the state up to date on startup. Saved cursors continue
+// from the last processed query, without them today's usage is backfilled
+// from the query log. Either way block status is synced with Pi-hole.
+func (a *App) catchUp() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ctx, cancel := context.WithTimeout(a.ctx, piholeTimeout)
+	defer cancel()
+
+	// Blocks of older versions count below
+	migrated, err := a.client.MigrateGroups(ctx, a.cfg.Services)
+	if err != nil {
+		fmt.Printf("Failed to migrate block groups: %v\n", err)
+	}
+	if migrated > 0 {
+		fmt.Printf("Migrated %d block groups to one group per service\n", migrated)
+	}
+
+	if a.stats.Day != today(a.cfg.Now()) {
+		a.startNewDay(ctx)
+	}
+	if a.stats.fromLegacy() {
+		// State of a version before per-service budgets. Its counters cover
+		// the queries up to the last one processed, the next check counts the
+		// later ones, and its blocks are applied to the block groups by the
+		// next check instead of being read from Pi-hole
+		fmt.Println("Migrated state of an older version to per-service budgets")
+		a.saveState()
+		return
+	}
+	if len(a.stats.Cursors) == 0 {
+		if err := a.backfill(ctx); err != nil {
+			fmt.Printf("Failed to backfill usage: %v\n", err)
+		}
+		return
+	}
+	if err := a.reconcileBlocks(ctx); err != nil {
+		fmt.Printf("Failed to sync block status with Pi-hole: %v\n", err)
+	}
+	a.saveState()
+}
+
+// backfill recounts today's usage from every query since midnight and syncs
+// block status with Pi-hole. Queries before a client reset are not counted.
+// Callers must hold a.mu.
+func (a *App) backfill(ctx context.Context) error {
+	fmt.Println("Backfilling today's usage from the Pi-hole query log...")
+	for _, client := range a.stats.Clients {
+		for _, usage := range client.Services {
+			usage.RequestsToday = 0
+			usage.TimeWatchedToday = 0
+			usage.WatchIntervals = nil
+			usage.LastQueryTime = time.Time{}
+			usage.Noise = NoiseState{}
+			usage.Statuses = nil
+		}
+	}
+	a.stats.Cursors = nil
+
+	a.refreshDevices(ctx)
+	err := checkDomains(ctx, a.client, a.cfg, &a.stats, a.devices)
+	if err != nil {
+		err = fmt.Errorf("failed to fetch queries: %w", err)
+	} else if err = a.reconcileBlocks(ctx); err != nil {
+		err = fmt.Errorf("failed to sync block status: %w", err)
+	}
+	a.saveState()
+	printStats(&a.stats)
+	return err
+}
+
+// reconcileBlocks makes the Blocked flags match the block groups in Pi-hole.
+// Blocks found in Pi-hole only are treated as curfew blocks outside of the
+// allowed hours and limit blocks otherwise, so the next check lifts them if
+// they are not due. Callers must hold a.mu.
+func (a *App) reconcileBlocks(ctx context.Context) error {
+	var services []string
+	for _, service := range a.cfg.Services {
+		services = append(services, service.Name)
+	}
+	blocked, err := a.client.BlockedClients(ctx, services)
+	if err != nil {
+		return err
+	}
+
+	for _, keys := range blocked {
+		for _, key := range keys {
+			if clientByKey(&a.stats, key) == nil {
+				client := NewClientStats(identityFromKey(key))
+				client.BlockedAs = key
+				a.stats.Clients = append(a.stats.Clients, client)
+			}
+		}
+	}
+
+	for _, client := range a.stats.Clients {
+		for _, service := range a.cfg.Services {
+			usage := client.usage(service.Name)
+			inPihole := slices.Contains(blocked[service.Name], client.blockedAs())
+			if usage.Blocked == inPihole {
+				continue
+			}
+			usage.Blocked = inPihole
+			usage.BlockReason = ""
+			if inPihole {
+				fmt.Printf("Client %s is blocked for %s in Pi-hole. Updating state...\n", client, service.Name)
+				usage.BlockReason = a.blockReason(client)
+			} else {
+				fmt.Printf("Client %s is not blocked for %s in Pi-hole. Updating state...\n", client, service.Name)
+			}
+		}
+	}
+
+	// A person is blocked when any of their devices is, the next check
+	// blocks the others
+	a.updatePeople()
+	for _, person := range a.stats.People {
+		for _, service := range a.cfg.Services {
+			usage := person.usage(service.Name)
+			blocked := slices.ContainsFunc(person.clients, func(client *Client) bool {
+				return client.usage(service.Name).Blocked
+			})
+			if usage.Blocked == blocked {
+				continue
+			}
+			usage.Blocked = blocked
+			usage.BlockReason = ""
+			if blocked {
+				usage.BlockReason = a.blockReason(person)
+			}
+		}
+	}
+	return nil
+}
+
+// repairBlocks makes the block groups in Pi-hole match the Blocked flags, the
+// other direction of reconcileBlocks. After startup the flags are what the
+// budgets and schedules want, so changes made in the Pi-hole web interface
+// and blocks that failed halfway are undone. Callers must hold a.mu.
+func (a *App) repairBlocks(ctx context.Context) {
+	blocked := map[string][]string{}
+	for _, client := range a.stats.Clients {
+		for _, service := range a.cfg.Services {
+			if usage, ok := client.Services[service.Name]; ok && usage.Blocked {
+				blocked[service.Name] = append(blocked[service.Name], client.blockedAs())
+			}
+		}
+	}
+
+	fixed, err := a.client.RepairBlocks(ctx, a.cfg.Services, blocked)
+	if err != nil {
+		fmt.Printf("Failed to repair blocks in Pi-hole: %v\n", err)
+	}
+	if fixed > 0 {
+		a.tgClient.SendMessage(fmt.Sprintf("Fixed %d changes to the blocks in Pi-hole", fixed))
+	}
+}
+
+// blockReason guesses why an account was blocked. Callers must hold a.mu.
+func (a *App) blockReason(acct account) string {
+	if profile, ok := acct.profile(a.cfg); ok {
+		if allowed, _ := config.AllowedAt(profile.AllowedWindows, a.cfg.Now()); !allowed {
+			return BlockReasonCurfew
+		}
+	}
+	return BlockReasonLimit
+}
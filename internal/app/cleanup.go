@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"fmt"
+)
+
+// cleanup forgets clients that were not seen for CleanupAfter days and
+// deletes the Pi-hole clients created for them, along with the groups and
+// deny rules of services that are gone. Blocked clients and clients with an explicit
+// profile or owner are kept. Callers must hold a.mu.
+func (a *App) cleanup(ctx context.Context) {
+	if a.cfg.CleanupAfter == 0 {
+		return
+	}
+	cutoff := a.cfg.Now().AddDate(0, 0, -a.cfg.CleanupAfter)
+	configured := map[*Client]bool{}
+	for _, key := range a.configuredClients() {
+		if client := clientByKey(&a.stats, key); client != nil {
+			configured[client] = true
+		}
+	}
+
+	var clients []*Client
+	var keep []string
+	for _, client := range a.stats.Clients {
+		if client.LastSeen.Before(cutoff) && !client.blocked() && !configured[client] {
+			fmt.Printf("Client %s was not seen since %s. Forgetting it...\n", client, client.LastSeen.Format("2006-01-02"))
+			continue
+		}
+		clients = append(clients, client)
+		keep = append(keep, client.blockedAs(), client.piholeClient())
+	}
+	a.stats.Clients = clients
+	a.updatePeople()
+
+	deleted, err := a.client.Cleanup(ctx, a.cfg.Services, keep)
+	if err != nil {
+		fmt.Printf("Failed to clean up Pi-hole: %v\n", err)
+	}
+	if deleted > 0 {
+		fmt.Printf("Deleted %d unused groups, clients and domains from Pi-hole\n", deleted)
+	}
+}
+
+// blocked reports whether the client is blocked for any service.
+func (c *Client) blocked() bool {
+	for _, usage := range c.Services {
+		if usage.Blocked {
+			return true
+		}
+	}
+	return false
+}
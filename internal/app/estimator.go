@@ -0,0 +1,93 @@
+package app
+
+import (
+	"time"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+)
+
+// UsageEstimator turns the queries of a service into watched time.
+type UsageEstimator interface {
+	// Record adds a query for the domain at t to the usage. Queries are
+	// recorded in chronological order.
+	Record(usage *ServiceUsage, domain string, t time.Time)
+}
+
+// NewUsageEstimator returns the estimator selected in the service config.
+func NewUsageEstimator(usage config.Usage) UsageEstimator {
+	switch usage.Estimator {
+	case config.EstimatorMinQueries:
+		return minQueriesEstimator{window: usage.Window, minQueries: usage.MinQueries}
+	case config.EstimatorWeighted:
+		return weightedEstimator{window: usage.Window, usage: usage}
+	default:
+		return windowEstimator{window: usage.Window}
+	}
+}
+
+// windowEstimator counts a window for every query outside of the previous one.
+type windowEstimator struct {
+	window time.Duration
+}
+
+func (e windowEstimator) Record(usage *ServiceUsage, domain string, t time.Time) {
+	recordInterval(usage, t, e.window, 1, 1)
+}
+
+// minQueriesEstimator counts a window once it has enough queries, so a single
+// thumbnail fetch is not counted as watching.
+type minQueriesEstimator struct {
+	window     time.Duration
+	minQueries int
+}
+
+func (e minQueriesEstimator) Record(usage *ServiceUsage, domain string, t time.Time) {
+	recordInterval(usage, t, e.window, 1, float64(e.minQueries))
+}
+
+// weightedEstimator counts a window once the weights of its queries add up to
+// 1, e.g. video segments weigh 1 and thumbnails 0.
+type weightedEstimator struct {
+	window time.Duration
+	usage  config.Usage
+}
+
+func (e weightedEstimator) Record(usage *ServiceUsage, domain string, t time.Time) {
+	recordInterval(usage, t, e.window, e.usage.Weight(domain), 1)
+}
+
+// recordInterval adds the query to the active watch interval or starts a new
+// one. An interval is counted as watched once its weight reaches threshold.
+func recordInterval(usage *ServiceUsage, t time.Time, window time.Duration, weight, threshold float64) {
+	usage.RequestsToday++
+
+	var interval *WatchIntervals
+	if n := len(usage.WatchIntervals); n > 0 {
+		interval = &usage.WatchIntervals[n-1]
+	}
+
+	// check if last interval is active
+	if interval != nil && !t.Before(interval.Start) && t.Before(interval.End) {
+		interval.Requests++
+		interval.Weight += weight
+	} else {
+		// A query soon after the last window continues it
+		start := t
+		if interval != nil && t.Sub(interval.End) < window {
+			start = interval.End
+		}
+
+		usage.WatchIntervals = append(usage.WatchIntervals, WatchIntervals{
+			Start:    start,
+			End:      start.Add(window),
+			Requests: 1,
+			Weight:   weight,
+		})
+		interval = &usage.WatchIntervals[len(usage.WatchIntervals)-1]
+	}
+
+	if !interval.Counted && interval.Weight >= threshold {
+		interval.Counted = true
+		usage.TimeWatchedToday += interval.End.Sub(interval.Start)
+	}
+}
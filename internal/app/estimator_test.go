@@ -0,0 +1,163 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+	"github.com/vladikamira/pihole-parental-control/internal/domain"
+)
+
+var t0 = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+// at returns t0 plus minutes.
+func at(minutes float64) time.Time {
+	return t0.Add(time.Duration(minutes * float64(time.Minute)))
+}
+
+type interval struct {
+	start, end float64 // minutes after t0
+	counted    bool
+}
+
+func TestEstimators(t *testing.T) {
+	weighted := config.Usage{
+		Estimator: config.EstimatorWeighted,
+		Window:    5 * time.Minute,
+		WeightRules: []config.WeightRule{
+			{Pattern: mustParse(t, "keyword:googlevideo"), Weight: 1},
+			{Pattern: mustParse(t, "domain:youtube.com"), Weight: 0.5},
+		},
+		DefaultWeight: 0,
+	}
+
+	tests := []struct {
+		name      string
+		usage     config.Usage
+		queries   []float64 // minutes after t0
+		domains   []string  // per query, default www.youtube.com
+		watched   time.Duration
+		intervals []interval
+	}{
+		{
+			name:      "window: single query",
+			usage:     config.Usage{Window: 5 * time.Minute},
+			queries:   []float64{0},
+			watched:   5 * time.Minute,
+			intervals: []interval{{0, 5, true}},
+		},
+		{
+			name:      "window: queries within the window",
+			usage:     config.Usage{Window: 5 * time.Minute},
+			queries:   []float64{0, 1, 4.9},
+			watched:   5 * time.Minute,
+			intervals: []interval{{0, 5, true}},
+		},
+		{
+			name:      "window: query at the end continues the window",
+			usage:     config.Usage{Window: 5 * time.Minute},
+			queries:   []float64{0, 5},
+			watched:   10 * time.Minute,
+			intervals: []interval{{0, 5, true}, {5, 10, true}},
+		},
+		{
+			name:      "window: query soon after the window continues it",
+			usage:     config.Usage{Window: 5 * time.Minute},
+			queries:   []float64{0, 9.9},
+			watched:   10 * time.Minute,
+			intervals: []interval{{0, 5, true}, {5, 10, true}},
+		},
+		{
+			name:      "window: query a window after the end starts anew",
+			usage:     config.Usage{Window: 5 * time.Minute},
+			queries:   []float64{0, 10},
+			watched:   10 * time.Minute,
+			intervals: []interval{{0, 5, true}, {10, 15, true}},
+		},
+		{
+			name:      "min_queries: too few queries",
+			usage:     config.Usage{Estimator: config.EstimatorMinQueries, Window: 5 * time.Minute, MinQueries: 3},
+			queries:   []float64{0, 1},
+			watched:   0,
+			intervals: []interval{{0, 5, false}},
+		},
+		{
+			name:      "min_queries: threshold reached",
+			usage:     config.Usage{Estimator: config.EstimatorMinQueries, Window: 5 * time.Minute, MinQueries: 3},
+			queries:   []float64{0, 1, 2},
+			watched:   5 * time.Minute,
+			intervals: []interval{{0, 5, true}},
+		},
+		{
+			name:      "min_queries: continued window needs its own queries",
+			usage:     config.Usage{Estimator: config.EstimatorMinQueries, Window: 5 * time.Minute, MinQueries: 3},
+			queries:   []float64{0, 1, 2, 6, 12, 13, 14},
+			watched:   10 * time.Minute,
+			intervals: []interval{{0, 5, true}, {5, 10, false}, {10, 15, true}},
+		},
+		{
+			name:      "weighted: thumbnails alone are not watching",
+			usage:     weighted,
+			queries:   []float64{0, 1, 2},
+			domains:   []string{"i.ytimg.com", "i.ytimg.com", "i.ytimg.com"},
+			watched:   0,
+			intervals: []interval{{0, 5, false}},
+		},
+		{
+			name:      "weighted: a video segment counts the window",
+			usage:     weighted,
+			queries:   []float64{0, 3},
+			domains:   []string{"i.ytimg.com", "rr1---sn-x.googlevideo.com"},
+			watched:   5 * time.Minute,
+			intervals: []interval{{0, 5, true}},
+		},
+		{
+			name:      "weighted: weights add up",
+			usage:     weighted,
+			queries:   []float64{0, 1, 5.5, 16},
+			domains:   []string{"www.youtube.com", "m.youtube.com", "www.youtube.com", "www.youtube.com"},
+			watched:   5 * time.Minute,
+			intervals: []interval{{0, 5, true}, {5, 10, false}, {16, 21, false}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			estimator := NewUsageEstimator(tt.usage)
+			usage := &ServiceUsage{}
+			for i, minutes := range tt.queries {
+				name := "www.youtube.com"
+				if tt.domains != nil {
+					name = tt.domains[i]
+				}
+				estimator.Record(usage, name, at(minutes))
+			}
+
+			if usage.RequestsToday != len(tt.queries) {
+				t.Errorf("RequestsToday = %d, want %d", usage.RequestsToday, len(tt.queries))
+			}
+			if usage.TimeWatchedToday != tt.watched {
+				t.Errorf("TimeWatchedToday = %v, want %v", usage.TimeWatchedToday, tt.watched)
+			}
+			if len(usage.WatchIntervals) != len(tt.intervals) {
+				t.Fatalf("got %d intervals, want %d: %+v", len(usage.WatchIntervals), len(tt.intervals), usage.WatchIntervals)
+			}
+			for i, want := range tt.intervals {
+				got := usage.WatchIntervals[i]
+				if !got.Start.Equal(at(want.start)) || !got.End.Equal(at(want.end)) || got.Counted != want.counted {
+					t.Errorf("interval %d = %v-%v counted %v, want %v-%v counted %v", i,
+						got.Start.Sub(t0), got.End.Sub(t0), got.Counted, at(want.start).Sub(t0), at(want.end).Sub(t0), want.counted)
+				}
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, s string) domain.Pattern {
+	t.Helper()
+	p, err := domain.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
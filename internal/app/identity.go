@@ -0,0 +1,274 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+	"github.com/vladikamira/pihole-parental-control/internal/pihole"
+)
+
+// Identity tells devices apart. DHCP hands out new addresses and dual-stack
+// devices use several IPv6 addresses next to their IPv4 address, so a device
+// is known by its MAC address from the Pi-hole network table, by its host
+// name when Pi-hole has no MAC address for it, and by its IP address only as
+// a last resort.
+type Identity struct {
+	MAC      string
+	Hostname string
+	IP       string
+}
+
+// ID returns the most stable identifier of the device.
+func (i Identity) ID() string {
+	switch {
+	case i.MAC != "":
+		return i.MAC
+	case i.Hostname != "":
+		return i.Hostname
+	}
+	return i.IP
+}
+
+// identityFromKey returns the identity of a client key, as used in the
+// config and by Pi-hole: a MAC address, an IP address or a host name.
+func identityFromKey(key string) Identity {
+	key = config.NormalizeClient(key)
+	if _, err := net.ParseMAC(key); err == nil {
+		return Identity{MAC: key}
+	}
+	if net.ParseIP(key) != nil {
+		return Identity{IP: key}
+	}
+	return Identity{Hostname: key}
+}
+
+// devices maps IP addresses to the devices that used them last.
+type devices map[string]Identity
+
+func newDevices(list []pihole.Device) devices {
+	d := devices{}
+	lastSeen := map[string]int64{}
+	for _, device := range list {
+		mac := device.MAC()
+		// The network table lists the IPv6 addresses of a device from the
+		// neighbor cache next to its IPv4 address
+		for _, address := range device.IPs {
+			ip := config.NormalizeClient(address.IP)
+			// An address reused by another device belongs to the newer one
+			if seen, ok := lastSeen[ip]; ok && seen >= address.LastSeen {
+				continue
+			}
+			id := Identity{MAC: mac, IP: ip}
+			if address.Name != nil {
+				id.Hostname = config.NormalizeClient(*address.Name)
+			}
+			d[ip] = id
+			lastSeen[ip] = address.LastSeen
+		}
+	}
+	return d
+}
+
+// resolve returns the identity of the device that sent a query.
+func (d devices) resolve(client pihole.QueryClient) Identity {
+	ip := config.NormalizeClient(client.IP)
+	id := d[ip]
+	id.IP = ip
+	// Pi-hole names clients it cannot resolve by their address
+	if id.Hostname == "" && client.Name != nil && *client.Name != client.IP {
+		id.Hostname = config.NormalizeClient(*client.Name)
+	}
+	return id
+}
+
+// refreshDevices reads the Pi-hole network table. The previous table is kept
+// when it cannot be read. Callers must hold a.mu.
+func (a *App) refreshDevices(ctx context.Context) {
+	list, err := a.client.Devices(ctx)
+	if err != nil {
+		fmt.Printf("Failed to get network devices: %v\n", err)
+		return
+	}
+	a.devices = newDevices(list)
+}
+
+// findClient returns the client of the device. Clients known by a less stable
+// identifier before, e.g. by the IP address until the MAC address was known,
+// are found by it as well.
+func findClient(stats *DomainStats, id Identity) *Client {
+	for _, client := range stats.Clients {
+		if client.ID == id.ID() {
+			return client
+		}
+	}
+	for _, client := range stats.Clients {
+		if client.MAC != "" {
+			continue
+		}
+		if (id.Hostname != "" && client.ID == id.Hostname) || (id.IP != "" && client.ID == id.IP) {
+			return client
+		}
+	}
+	return nil
+}
+
+// clientByKey returns the client with the MAC address, host name or IP
+// address key.
+func clientByKey(stats *DomainStats, key string) *Client {
+	key = config.NormalizeClient(key)
+	if key == "" {
+		return nil
+	}
+	for _, client := range stats.Clients {
+		if client.ID == key || client.MAC == key || client.Hostname == key || slices.Contains(client.Addresses, key) {
+			return client
+		}
+	}
+	return nil
+}
+
+// identity returns what is known about the device of the client.
+func (c *Client) identity() Identity {
+	return Identity{MAC: c.MAC, Hostname: c.Hostname, IP: c.IP}
+}
+
+// identify updates the client with a newer identity of its device. The IP
+// address is only an attribute and follows the device.
+func (c *Client) identify(id Identity) {
+	if id.MAC != "" {
+		c.MAC = id.MAC
+	}
+	if id.Hostname != "" {
+		c.Hostname = id.Hostname
+	}
+	if id.IP != "" {
+		c.IP = id.IP
+		if !slices.Contains(c.Addresses, id.IP) {
+			c.Addresses = append(c.Addresses, id.IP)
+		}
+	}
+	c.ID = c.identity().ID()
+}
+
+// piholeClient returns the Pi-hole client to block. Pi-hole matches MAC
+// addresses and host names for every address of the device, IPv4 and IPv6,
+// so the block holds when the device changes or adds addresses.
+func (c *Client) piholeClient() string {
+	return c.identity().ID()
+}
+
+// blockedAs returns the Pi-hole client the blocks of the client were added to.
+func (c *Client) blockedAs() string {
+	if c.BlockedAs != "" {
+		return c.BlockedAs
+	}
+	return c.piholeClient()
+}
+
+// String names the client in logs and notifications.
+func (c *Client) String() string {
+	switch {
+	case c.Hostname != "":
+		return c.Hostname
+	case c.IP != "":
+		return c.IP
+	}
+	return c.MAC
+}
+
+// moveBlocks moves the blocks of a client to its current Pi-hole client, e.g.
+// from its IP address to its MAC address once that is known. Callers must
+// hold a.mu.
+func (a *App) moveBlocks(ctx context.Context, client *Client) {
+	from, to := client.blockedAs(), client.piholeClient()
+	if from == to {
+		return
+	}
+	for _, service := range a.cfg.Services {
+		if !client.usage(service.Name).Blocked {
+			continue
+		}
+		fmt.Printf("Client %s is now known as %s in Pi-hole. Moving its %s block...\n", client, to, service.Name)
+		if err := a.client.BlockDomainsForClient(ctx, to, service.Name, service.Patterns); err != nil {
+			// Retried on the next check, the old block still holds
+			fmt.Printf("Failed to block %s for client %s: %v\n", service.Name, to, err)
+			return
+		}
+		if err := a.client.UnblockDomainsForClient(ctx, from, service.Name); err != nil {
+			fmt.Printf("Failed to unblock %s for client %s: %v\n", service.Name, from, err)
+		}
+	}
+	client.BlockedAs = to
+}
+
+// mergeAddresses merges clients known only by an IP address into the client
+// of their device, once the Pi-hole network table links the address to it.
+// Until then, e.g. for a new IPv6 privacy address, queries are counted for
+// the address on its own. Callers must hold a.mu.
+func (a *App) mergeAddresses(ctx context.Context) {
+	var clients []*Client
+	for _, client := range a.stats.Clients {
+		device := a.addressOwner(client)
+		if device == nil {
+			clients = append(clients, client)
+			continue
+		}
+		fmt.Printf("Address %s belongs to client %s. Merging its usage...\n", client.IP, device)
+		for _, service := range a.cfg.Services {
+			if client.usage(service.Name).Blocked {
+				a.unblock(ctx, client, service)
+			}
+		}
+		device.absorb(client)
+	}
+	a.stats.Clients = clients
+}
+
+// addressOwner returns the client of the device the network table links the
+// address of a client known only by it to.
+func (a *App) addressOwner(client *Client) *Client {
+	if client.MAC != "" || client.Hostname != "" {
+		return nil
+	}
+	id, ok := a.devices[client.IP]
+	if !ok || id.ID() == client.ID {
+		return nil
+	}
+	for _, device := range a.stats.Clients {
+		if device != client && device.ID == id.ID() {
+			return device
+		}
+	}
+	return nil
+}
+
+// absorb adds the usage of another address of the device.
+func (c *Client) absorb(other *Client) {
+	for service, usage := range other.Services {
+		own := c.usage(service)
+		own.RequestsToday += usage.RequestsToday
+		own.WatchIntervals = append(own.WatchIntervals, usage.WatchIntervals...)
+		sort.Slice(own.WatchIntervals, func(i, j int) bool {
+			return own.WatchIntervals[i].Start.Before(own.WatchIntervals[j].Start)
+		})
+		_, own.TimeWatchedToday = mergeIntervals(own.WatchIntervals)
+		if usage.LastQueryTime.After(own.LastQueryTime) {
+			own.LastQueryTime = usage.LastQueryTime
+		}
+		for status, n := range usage.Statuses {
+			if own.Statuses == nil {
+				own.Statuses = map[string]int{}
+			}
+			own.Statuses[status] += n
+		}
+	}
+	for _, address := range other.Addresses {
+		if !slices.Contains(c.Addresses, address) {
+			c.Addresses = append(c.Addresses, address)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// legacyUsage is the usage of a client in the state of versions before
+// per-service budgets, which only counted YouTube.
+type legacyUsage struct {
+	RequestsToday     int              `json:"requests_today"`
+	Limit             time.Duration    `json:"limit"`
+	LimitRule         string           `json:"limit_rule"`
+	TimeWatchedToday  time.Duration    `json:"time_watched_today"`
+	WatchIntervals    []WatchIntervals `json:"watch_intervals"`
+	LastQueryTime     time.Time        `json:"last_query_time"`
+	Blocked           bool             `json:"blocked"`
+	BlockReason       string           `json:"block_reason"`
+	NotifiedNearLimit bool             `json:"notified_near_limit"`
+}
+
+// UnmarshalJSON loads a client, moving the usage of older versions into the
+// usage of youtube.
+func (c *Client) UnmarshalJSON(data []byte) error {
+	type plain Client
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	if c.Services != nil {
+		return nil
+	}
+
+	var old legacyUsage
+	if err := json.Unmarshal(data, &old); err != nil {
+		return err
+	}
+	c.Services = map[string]*ServiceUsage{"youtube": {
+		RequestsToday:     old.RequestsToday,
+		TimeWatchedToday:  old.TimeWatchedToday,
+		WatchIntervals:    old.WatchIntervals,
+		LastQueryTime:     old.LastQueryTime,
+		Limit:             old.Limit,
+		LimitRule:         old.LimitRule,
+		Blocked:           old.Blocked,
+		BlockReason:       old.BlockReason,
+		NotifiedNearLimit: old.NotifiedNearLimit,
+	}}
+	if old.Blocked && old.BlockReason == "" {
+		// Versions before curfews only blocked for the limit
+		c.Services["youtube"].BlockReason = BlockReasonLimit
+	}
+	c.legacy = true
+	return nil
+}
+
+// fromLegacy reports whether the state was saved by a version before
+// per-service budgets.
+func (s *DomainStats) fromLegacy() bool {
+	for _, client := range s.Clients {
+		if client.legacy {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLoadLegacyState(t *testing.T) {
+	// Saved by a version with curfews, before per-service budgets
+	data := `{
+	"day": "2026-10-16",
+	"domains": ["youtube.com"],
+	"global_count": 42,
+	"clients": [
+		{
+			"ip": "192.168.1.15",
+			"profile": "kids",
+			"requests_today": 40,
+			"time_watched_today": 3600000000000,
+			"watch_intervals": [{"start": "2026-10-16T17:00:00Z", "end": "2026-10-16T18:00:00Z", "requests": 40}],
+			"last_query_time": "2026-10-16T17:59:00Z",
+			"limit": 3600000000000,
+			"limit_rule": "default",
+			"blocked": true,
+			"block_reason": "limit",
+			"notified_near_limit": true,
+			"notified_curfew": true
+		},
+		{"ip": "192.168.1.16", "requests_today": 2, "blocked": true}
+	]
+}`
+	var stats DomainStats
+	if err := json.Unmarshal([]byte(data), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if !stats.fromLegacy() {
+		t.Error("fromLegacy() = false, want true")
+	}
+	if len(stats.Clients) != 2 {
+		t.Fatalf("got %d clients, want 2", len(stats.Clients))
+	}
+
+	client := stats.Clients[0]
+	if client.IP != "192.168.1.15" || client.Profile != "kids" || !client.NotifiedCurfew {
+		t.Errorf("client = %+v, want 192.168.1.15 with profile kids, notified of the curfew", client)
+	}
+	if len(client.Services) != 1 || client.Services["youtube"] == nil {
+		t.Fatalf("services = %v, want youtube only", client.Services)
+	}
+	usage := client.Services["youtube"]
+	if usage.RequestsToday != 40 || usage.TimeWatchedToday != time.Hour || len(usage.WatchIntervals) != 1 ||
+		!usage.LastQueryTime.Equal(time.Date(2026, 10, 16, 17, 59, 0, 0, time.UTC)) ||
+		usage.Limit != time.Hour || usage.LimitRule != "default" || !usage.NotifiedNearLimit {
+		t.Errorf("youtube usage = %+v, want the counters of the old state", usage)
+	}
+	if !usage.Blocked || usage.BlockReason != BlockReasonLimit {
+		t.Errorf("youtube blocked = %v for %q, want blocked for the limit", usage.Blocked, usage.BlockReason)
+	}
+
+	// Versions before curfews did not save a reason
+	if usage := stats.Clients[1].Services["youtube"]; !usage.Blocked || usage.BlockReason != BlockReasonLimit {
+		t.Errorf("second client blocked = %v for %q, want blocked for the limit", usage.Blocked, usage.BlockReason)
+	}
+}
+
+func TestLoadCurrentState(t *testing.T) {
+	client := NewClientStats(identityFromKey("192.168.1.15"))
+	client.usage("tiktok").Blocked = true
+	data, err := json.Marshal(DomainStats{Day: "2026-10-16", Clients: []*Client{client}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stats DomainStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.fromLegacy() {
+		t.Error("fromLegacy() = true, want false")
+	}
+	got := stats.Clients[0]
+	if got.ID != client.ID || len(got.Services) != 1 || !got.Services["tiktok"].Blocked {
+		t.Errorf("client = %+v, want it unchanged", got)
+	}
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+)
+
+// newMessageData builds the template data. service is empty for messages that
+// are not about a single service, such as curfews.
+func newMessageData(acct account, service string, profile config.Profile, remaining time.Duration) config.MessageData {
+	data := config.MessageData{
+		Client:    acct.String(),
+		Profile:   profile.Name,
+		Service:   service,
+		Remaining: remaining.Round(time.Minute),
+		Minutes:   int(remaining.Round(time.Minute).Minutes()),
+	}
+	if usage, ok := acct.budget().Services[service]; ok {
+		data.Limit = usage.Limit
+		data.Watched = usage.TimeWatchedToday
+	}
+	return data
+}
+
+// renderMessage executes the message template. Messages that fail to render
+// are sent as is.
+func renderMessage(message string, data config.MessageData) string {
+	tmpl, err := template.New("message").Parse(message)
+	if err != nil {
+		fmt.Printf("Failed to parse message template %q: %v\n", message, err)
+		return message
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		fmt.Printf("Failed to render message template %q: %v\n", message, err)
+		return message
+	}
+	return buf.String()
+}
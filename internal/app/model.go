@@ -1,12 +1,14 @@
 package app
 
 import (
+	"context"
 	"sync"
 	"time"
 
 	"github.com/vladikamira/pihole-parental-control/internal/config"
 	"github.com/vladikamira/pihole-parental-control/internal/pihole"
 	"github.com/vladikamira/pihole-parental-control/internal/speaker"
+	"github.com/vladikamira/pihole-parental-control/internal/store"
 	"github.com/vladikamira/pihole-parental-control/internal/telegram"
 )
 
@@ -14,29 +16,102 @@ type WatchIntervals struct {
 	Start    time.Time `json:"start"`
 	End      time.Time `json:"end"`
 	Requests int       `json:"requests"`
+	Weight   float64   `json:"weight"`
+	Counted  bool      `json:"counted"`
 }
 
 type App struct {
 	cfg           config.Config
+	configPath    string
+	ctx           context.Context // canceled on shutdown
+	reload        chan struct{}
 	client        *pihole.Client
 	tgClient      *telegram.Client
 	speakerClient *speaker.Client
+	store         store.Store
 	stats         DomainStats
+	devices       devices
+	piholeDown    bool // reported as down
 	mu            sync.RWMutex
 }
 
+// Client is a device, identified by ID: its MAC address, host name or IP
+// address, whichever is known and most stable. The other fields show the
+// device as last seen.
 type Client struct {
+	ID        string   `json:"id"`
+	MAC       string   `json:"mac,omitempty"`
+	Hostname  string   `json:"hostname,omitempty"`
+	IP        string   `json:"ip"`                   // last seen
+	Addresses []string `json:"addresses"`            // every address seen, e.g. IPv4 and IPv6
+	BlockedAs string   `json:"blocked_as,omitempty"` // Pi-hole client of the blocks
+	Person    string   `json:"person,omitempty"`
+	Budget
+	ResetAt  time.Time `json:"reset_at,omitempty"`
+	LastSeen time.Time `json:"last_seen"` // last query, or when the client was added
+
+	legacy bool // loaded from the state of a version before per-service budgets
+}
+
+// Budget is the daily usage a profile limits: of a client on its own, or of a
+// person with all of their devices.
+type Budget struct {
+	Profile        string                   `json:"profile"`
+	Services       map[string]*ServiceUsage `json:"services"`
+	NotifiedCurfew bool                     `json:"notified_curfew"`
+}
+
+// Person shares one budget between the clients of their devices. The usage of
+// the person is merged from the usage of the clients.
+type Person struct {
+	Name    string   `json:"name"`
+	Devices []string `json:"devices"` // client IDs
+	Budget
+
+	clients []*Client
+}
+
+// ServiceUsage is the daily usage of a single service by a client.
+type ServiceUsage struct {
 	RequestsToday     int              `json:"requests_today"`
-	IP                string           `json:"ip"`
 	TimeWatchedToday  time.Duration    `json:"time_watched_today"`
 	WatchIntervals    []WatchIntervals `json:"watch_intervals"`
 	LastQueryTime     time.Time        `json:"last_query_time"`
+	Limit             time.Duration    `json:"limit"`
+	LimitRule         string           `json:"limit_rule"`
 	Blocked           bool             `json:"blocked"`
+	BlockReason       string           `json:"block_reason,omitempty"`
 	NotifiedNearLimit bool             `json:"notified_near_limit"`
+	Noise             NoiseState       `json:"noise"`
+	Statuses          map[string]int   `json:"statuses,omitempty"` // Pi-hole status -> queries today
 }
 
+const (
+	BlockReasonLimit  = "limit"
+	BlockReasonCurfew = "curfew"
+)
+
 type DomainStats struct {
-	Domains     []string  `json:"domains"`
-	GlobalCount int       `json:"global_count"`
-	Clients     []*Client `json:"clients"`
+	Day         string                  `json:"day"`
+	Services    map[string][]string     `json:"services"`
+	GlobalCount int                     `json:"global_count"`
+	QueryLog    QueryLogStats           `json:"query_log"`
+	Cursors     map[string]*QueryCursor `json:"query_cursors"`
+	Clients     []*Client               `json:"clients"`
+	People      []*Person               `json:"people,omitempty"`
+}
+
+// QueryCursor is the newest processed query of a query log filter. The next
+// check fetches the queries from there, so slow checks, failed polls and
+// restarts neither miss nor count queries twice.
+type QueryCursor struct {
+	Time   time.Time `json:"time"`
+	LastID int       `json:"last_id"`
+}
+
+// QueryLogStats shows how many pages of the Pi-hole query log the checks need.
+type QueryLogStats struct {
+	Pages     int `json:"pages"`     // read by the last check
+	MaxPages  int `json:"max_pages"` // most pages a single search needed today
+	Truncated int `json:"truncated"` // searches today that hit the page cap and continued on the next check
 }
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+	"github.com/vladikamira/pihole-parental-control/internal/pihole"
+)
+
+// Reasons for discarding a query.
+const (
+	DiscardBlocked    = "blocked"
+	DiscardCached     = "cached"
+	DiscardType       = "type"
+	DiscardStatus     = "status"
+	DiscardNoPlayback = "no_playback"
+	DiscardIsolated   = "isolated"
+)
+
+// NoiseState is the noise filter state of a client and service.
+type NoiseState struct {
+	Pending      []PendingQuery `json:"pending,omitempty"`
+	LastQuery    time.Time      `json:"last_query"`
+	LastPlayback time.Time      `json:"last_playback"`
+	Discarded    map[string]int `json:"discarded,omitempty"` // reason -> queries today
+}
+
+// PendingQuery waits until it is known whether it is noise.
+type PendingQuery struct {
+	Time   time.Time `json:"time"`
+	Domain string    `json:"domain"`
+}
+
+// filterNoise returns the queries of a client that should be counted, in
+// chronological order. queries are the new queries of the client and now is
+// the time every query was fetched up to, or zero if some polls failed.
+func filterNoise(noise config.Noise, state *NoiseState, queries []pihole.Query, now time.Time) []PendingQuery {
+	for _, query := range queries {
+		switch {
+		case query.Blocked():
+			// A blocked client keeps retrying, which is not watching
+			state.discard(DiscardBlocked)
+		case noise.IgnoreCached && query.Cached():
+			state.discard(DiscardCached)
+		case slices.Contains(noise.IgnoreTypes, query.Type):
+			state.discard(DiscardType)
+		case slices.Contains(noise.IgnoreStatuses, query.Status):
+			state.discard(DiscardStatus)
+		default:
+			state.Pending = append(state.Pending, PendingQuery{Time: queryTime(query), Domain: query.Domain})
+		}
+	}
+	// Queries of a failed poll arrive later than newer ones
+	sort.SliceStable(state.Pending, func(i, j int) bool {
+		return state.Pending[i].Time.Before(state.Pending[j].Time)
+	})
+
+	var kept []PendingQuery
+	for len(state.Pending) > 0 {
+		query, later := state.Pending[0], state.Pending[1:]
+		reason, decided := decideNoise(noise, state, query, later, now)
+		if !decided {
+			// Later queries wait as well, so they are counted in order
+			break
+		}
+		state.Pending = later
+
+		state.LastQuery = query.Time
+		if noise.Playback(query.Domain) {
+			state.LastPlayback = query.Time
+		}
+		if reason == "" {
+			kept = append(kept, query)
+		} else {
+			state.discard(reason)
+		}
+	}
+	if len(state.Pending) == 0 {
+		state.Pending = nil
+	}
+	return kept
+}
+
+func (s *NoiseState) discard(reason string) {
+	if s.Discarded == nil {
+		s.Discarded = map[string]int{}
+	}
+	s.Discarded[reason]++
+}
+
+// decideNoise returns the reason to discard the query, or "" to count it. decided
+// is false while queries that would change the decision may still arrive.
+func decideNoise(noise config.Noise, state *NoiseState, query PendingQuery, later []PendingQuery, now time.Time) (discard string, decided bool) {
+	final := !query.Time.Add(noise.Window).After(now)
+	near := func(t time.Time) bool {
+		return !t.IsZero() && query.Time.Sub(t) <= noise.Window
+	}
+
+	if noise.RequiresPlayback() && !noise.Playback(query.Domain) && !near(state.LastPlayback) {
+		found := false
+		for _, next := range later {
+			if next.Time.Sub(query.Time) > noise.Window {
+				break
+			}
+			if noise.Playback(next.Domain) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return DiscardNoPlayback, final
+		}
+	}
+
+	if noise.DropIsolated && !near(state.LastQuery) {
+		if len(later) == 0 || later[0].Time.Sub(query.Time) > noise.Window {
+			return DiscardIsolated, final
+		}
+	}
+	return "", true
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+	"github.com/vladikamira/pihole-parental-control/internal/domain"
+	"github.com/vladikamira/pihole-parental-control/internal/pihole"
+)
+
+// q returns a forwarded A query minutes after t0.
+func q(minutes float64, name string) pihole.Query {
+	return pihole.Query{Time: float64(at(minutes).Unix()), Type: "A", Status: "FORWARDED", Domain: name}
+}
+
+func withStatus(query pihole.Query, status string) pihole.Query {
+	query.Status = status
+	return query
+}
+
+func withType(query pihole.Query, queryType string) pihole.Query {
+	query.Type = queryType
+	return query
+}
+
+// poll is the new queries of one check and the time they were fetched up to,
+// in minutes after t0. A negative now is a failed poll.
+type poll struct {
+	queries []pihole.Query
+	now     float64
+	kept    []float64 // minutes of the queries counted by this poll
+}
+
+func TestFilterNoise(t *testing.T) {
+	playback := config.Noise{
+		Window:          5 * time.Minute,
+		RequireDomains:  []string{"keyword:googlevideo"},
+		RequirePatterns: []domain.Pattern{mustParse(t, "keyword:googlevideo")},
+	}
+	isolated := config.Noise{Window: 5 * time.Minute, DropIsolated: true}
+
+	tests := []struct {
+		name      string
+		noise     config.Noise
+		polls     []poll
+		discarded map[string]int
+		pending   int
+	}{
+		{
+			name:  "no filters count everything",
+			noise: config.Noise{Window: 5 * time.Minute},
+			polls: []poll{{queries: []pihole.Query{q(0, "www.youtube.com"), q(20, "www.youtube.com")}, now: 21, kept: []float64{0, 20}}},
+		},
+		{
+			name:  "blocked, cached, type and status",
+			noise: config.Noise{Window: 5 * time.Minute, IgnoreCached: true, IgnoreTypes: []string{"HTTPS"}, IgnoreStatuses: []string{"RETRIED"}},
+			polls: []poll{{
+				queries: []pihole.Query{
+					withStatus(q(0, "www.youtube.com"), "DENYLIST"),
+					withStatus(q(1, "www.youtube.com"), "REGEX"),
+					withStatus(q(2, "www.youtube.com"), "CACHE"),
+					withType(q(3, "www.youtube.com"), "HTTPS"),
+					withStatus(q(4, "www.youtube.com"), "RETRIED"),
+					q(5, "www.youtube.com"),
+				},
+				now:  10,
+				kept: []float64{5},
+			}},
+			discarded: map[string]int{DiscardBlocked: 2, DiscardCached: 1, DiscardType: 1, DiscardStatus: 1},
+		},
+		{
+			name:  "isolated query is dropped once its window passed",
+			noise: isolated,
+			polls: []poll{
+				{queries: []pihole.Query{q(0, "i.ytimg.com")}, now: 1},
+				{now: 4},
+				{now: 5},
+			},
+			discarded: map[string]int{DiscardIsolated: 1},
+		},
+		{
+			name:  "held back query is counted when the next one arrives in a later poll",
+			noise: isolated,
+			polls: []poll{
+				{queries: []pihole.Query{q(0, "www.youtube.com")}, now: 1},
+				{queries: []pihole.Query{q(3, "www.youtube.com")}, now: 4, kept: []float64{0, 3}},
+				{queries: []pihole.Query{q(7, "www.youtube.com")}, now: 8, kept: []float64{7}},
+			},
+		},
+		{
+			name:  "nothing is decided after a failed poll",
+			noise: isolated,
+			polls: []poll{
+				{queries: []pihole.Query{q(0, "www.youtube.com")}, now: -1},
+				{now: -1},
+				{now: 30},
+			},
+			discarded: map[string]int{DiscardIsolated: 1},
+		},
+		{
+			name:  "late queries of a failed poll are sorted in",
+			noise: isolated,
+			polls: []poll{
+				{queries: []pihole.Query{q(2, "www.youtube.com")}, now: -1},
+				{queries: []pihole.Query{q(0, "www.youtube.com")}, now: 10, kept: []float64{0, 2}},
+			},
+		},
+		{
+			name:  "undecided query holds back later ones",
+			noise: isolated,
+			polls: []poll{
+				{queries: []pihole.Query{q(0, "www.youtube.com")}, now: 1},
+				{queries: []pihole.Query{q(10, "www.youtube.com")}, now: -1},
+			},
+			pending: 2,
+		},
+		{
+			name:  "thumbnails without playback are dropped",
+			noise: playback,
+			polls: []poll{
+				{queries: []pihole.Query{q(0, "i.ytimg.com"), q(1, "i.ytimg.com")}, now: 2},
+				{now: 10},
+			},
+			discarded: map[string]int{DiscardNoPlayback: 2},
+		},
+		{
+			name:  "thumbnails near playback are counted",
+			noise: playback,
+			polls: []poll{
+				{queries: []pihole.Query{q(0, "i.ytimg.com")}, now: 1},
+				{queries: []pihole.Query{q(2, "rr1---sn-x.googlevideo.com"), q(6, "i.ytimg.com")}, now: 20, kept: []float64{0, 2, 6}},
+				{queries: []pihole.Query{q(30, "i.ytimg.com")}, now: 40},
+			},
+			discarded: map[string]int{DiscardNoPlayback: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &NoiseState{}
+			for i, p := range tt.polls {
+				now := time.Time{}
+				if p.now >= 0 {
+					now = at(p.now)
+				}
+				var kept []float64
+				for _, query := range filterNoise(tt.noise, state, p.queries, now) {
+					kept = append(kept, query.Time.Sub(t0).Minutes())
+				}
+				if !slices.Equal(kept, p.kept) {
+					t.Errorf("poll %d kept %v, want %v", i, kept, p.kept)
+				}
+			}
+			if len(tt.discarded) == 0 {
+				tt.discarded = nil
+			}
+			if !maps.Equal(state.Discarded, tt.discarded) {
+				t.Errorf("Discarded = %v, want %v", state.Discarded, tt.discarded)
+			}
+			if len(state.Pending) != tt.pending {
+				t.Errorf("%d queries pending, want %d", len(state.Pending), tt.pending)
+			}
+		})
+	}
+}
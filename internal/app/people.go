@@ -0,0 +1,177 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+)
+
+// account is what a profile is enforced on: a client on its own, or a person
+// with all of their devices.
+type account interface {
+	budget() *Budget
+	devices() []*Client
+	profile(cfg config.Config) (config.Profile, bool)
+	String() string
+}
+
+func (c *Client) budget() *Budget {
+	return &c.Budget
+}
+
+func (c *Client) devices() []*Client {
+	return []*Client{c}
+}
+
+func (c *Client) profile(cfg config.Config) (config.Profile, bool) {
+	return cfg.ProfileFor(c.MAC, c.Hostname, c.Addresses...)
+}
+
+func (p *Person) budget() *Budget {
+	return &p.Budget
+}
+
+func (p *Person) devices() []*Client {
+	return p.clients
+}
+
+func (p *Person) profile(cfg config.Config) (config.Profile, bool) {
+	person, ok := cfg.People[p.Name]
+	if !ok {
+		return config.Profile{}, false
+	}
+	profile, ok := cfg.Profiles[person.Profile]
+	return profile, ok
+}
+
+func (p *Person) String() string {
+	return p.Name
+}
+
+// accounts returns the people and the clients that belong to nobody.
+func (s *DomainStats) accounts() []account {
+	var accounts []account
+	for _, client := range s.Clients {
+		if client.Person == "" {
+			accounts = append(accounts, client)
+		}
+	}
+	for _, person := range s.People {
+		accounts = append(accounts, person)
+	}
+	return accounts
+}
+
+// updatePeople links the people of the config to the clients of their devices
+// and merges the usage of the devices. Callers must hold a.mu.
+func (a *App) updatePeople() {
+	people := map[string]*Person{}
+	for _, person := range a.stats.People {
+		people[person.Name] = person
+	}
+
+	// People removed from the config leave their clients on their own
+	a.stats.People = nil
+	for _, name := range slices.Sorted(maps.Keys(a.cfg.People)) {
+		person, ok := people[name]
+		if !ok {
+			person = &Person{Name: name}
+		}
+		person.Devices, person.clients = nil, nil
+		a.stats.People = append(a.stats.People, person)
+		people[name] = person
+	}
+
+	for _, client := range a.stats.Clients {
+		client.Person = ""
+		if owner, ok := a.cfg.PersonFor(client.MAC, client.Hostname, client.Addresses...); ok {
+			person := people[owner.Name]
+			client.Person = person.Name
+			person.Devices = append(person.Devices, client.ID)
+			person.clients = append(person.clients, client)
+		}
+	}
+
+	for _, person := range a.stats.People {
+		for _, service := range a.cfg.Services {
+			person.mergeUsage(service.Name)
+		}
+	}
+}
+
+// mergeUsage adds up the usage of the devices of the person. Watch intervals
+// of different devices that overlap are counted once, so watching on the TV
+// with the iPad open next to it does not use the budget twice.
+func (p *Person) mergeUsage(service string) {
+	usage := p.usage(service)
+	usage.RequestsToday = 0
+	usage.Statuses = nil
+
+	var intervals []WatchIntervals
+	for _, client := range p.clients {
+		device := client.usage(service)
+		usage.RequestsToday += device.RequestsToday
+		if device.LastQueryTime.After(usage.LastQueryTime) {
+			usage.LastQueryTime = device.LastQueryTime
+		}
+		intervals = append(intervals, device.WatchIntervals...)
+	}
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].Start.Before(intervals[j].Start)
+	})
+	usage.WatchIntervals, usage.TimeWatchedToday = mergeIntervals(intervals)
+}
+
+// mergeIntervals merges overlapping counted intervals, sorted by start, and
+// returns them with the time they cover.
+func mergeIntervals(intervals []WatchIntervals) ([]WatchIntervals, time.Duration) {
+	var merged []WatchIntervals
+	for _, interval := range intervals {
+		if !interval.Counted {
+			continue
+		}
+		n := len(merged)
+		if n > 0 && !interval.Start.After(merged[n-1].End) {
+			last := &merged[n-1]
+			if interval.End.After(last.End) {
+				last.End = interval.End
+			}
+			last.Requests += interval.Requests
+			last.Weight += interval.Weight
+			continue
+		}
+		merged = append(merged, interval)
+	}
+
+	var watched time.Duration
+	for _, interval := range merged {
+		watched += interval.End.Sub(interval.Start)
+	}
+	return merged, watched
+}
+
+// syncBlocks gives every device of the person the blocks of the person, e.g.
+// a device seen for the first time or one that was blocked on its own before
+// it was added to the person. Callers must hold a.mu.
+func (a *App) syncBlocks(ctx context.Context, person *Person) {
+	for _, service := range a.cfg.Services {
+		usage := person.usage(service.Name)
+		for _, client := range person.clients {
+			if client.usage(service.Name).Blocked == usage.Blocked {
+				continue
+			}
+			if usage.Blocked {
+				fmt.Printf("Client %s of %s is not blocked for %s yet. Blocking...\n", client, person, service.Name)
+				a.block(ctx, client, service, usage.BlockReason)
+			} else {
+				fmt.Printf("Client %s of %s is still blocked for %s. Unblocking...\n", client, person, service.Name)
+				a.unblock(ctx, client, service)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+	"github.com/vladikamira/pihole-parental-control/internal/domain"
+)
+
+// watchConfig reloads the config on SIGHUP and whenever the config file changes.
+func (a *App) watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var events chan fsnotify.Event
+	var errs chan error
+	if a.configPath != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			fmt.Printf("Failed to watch config file: %v\n", err)
+		} else if err := watcher.Add(filepath.Dir(a.configPath)); err != nil {
+			// Watch the directory: editors and Kubernetes replace the file instead of writing to it
+			fmt.Printf("Failed to watch config file: %v\n", err)
+			watcher.Close()
+		} else {
+			events = watcher.Events
+			errs = watcher.Errors
+		}
+	}
+
+	go func() {
+		// Editors emit several events per save, so wait for them to settle
+		debounce := time.NewTimer(time.Hour)
+		debounce.Stop()
+
+		for {
+			select {
+			case <-hup:
+				fmt.Println("Got SIGHUP. Reloading config...")
+				a.reloadConfig()
+			case event := <-events:
+				if configChanged(event, a.configPath) {
+					debounce.Reset(500 * time.Millisecond)
+				}
+			case err := <-errs:
+				// The watcher stops delivering events until its errors are read
+				fmt.Printf("Failed to watch config file: %v\n", err)
+			case <-debounce.C:
+				fmt.Println("Config file changed. Reloading config...")
+				a.reloadConfig()
+			}
+		}
+	}()
+}
+
+// configChanged reports whether the event changed the config file. Kubernetes
+// updates a mounted ConfigMap by swapping the "..data" symlink the file links
+// through, so that counts as well.
+func configChanged(event fsnotify.Event, configPath string) bool {
+	if event.Has(fsnotify.Chmod) {
+		return false
+	}
+	name := filepath.Base(event.Name)
+	return name == filepath.Base(configPath) || name == "..data"
+}
+
+// reloadConfig swaps in the new config and triggers an immediate check.
+// An invalid config is reported and the current one is kept.
+func (a *App) reloadConfig() {
+	cfg, err := config.Load(a.configPath)
+	if err != nil {
+		fmt.Printf("Failed to reload config, keeping the current one: %v\n", err)
+		a.tgClient.SendMessage(fmt.Sprintf("Failed to reload config: %v", err))
+		return
+	}
+
+	a.mu.Lock()
+	ctx, cancel := context.WithTimeout(a.ctx, piholeTimeout)
+	defer cancel()
+	old := a.cfg
+	a.liftRemovedServices(ctx, cfg)
+	a.cleanupRemovedDomains(ctx, old, cfg)
+	a.blockAddedDomains(ctx, old, cfg)
+
+	a.cfg = cfg
+	a.client.SetConfig(cfg)
+	a.tgClient.SetConfig(cfg)
+	a.speakerClient.SetConfig(cfg)
+	a.stats.Services = serviceDomains(cfg)
+	if cfg.ApiPort != old.ApiPort || cfg.StateBackend != old.StateBackend || cfg.StatePath != old.StatePath {
+		fmt.Println("API port and state settings only change after a restart")
+	}
+	a.saveState()
+	a.mu.Unlock()
+
+	fmt.Println("Config reloaded")
+	a.requestCheck()
+}
+
+// liftRemovedServices unblocks clients blocked for services that are gone
+// from cfg and forgets their usage. The usage of a client whose unblock fails
+// is kept, so the next check tries again. Callers must hold a.mu.
+func (a *App) liftRemovedServices(ctx context.Context, cfg config.Config) {
+	for _, client := range a.stats.Clients {
+		for name, usage := range client.Services {
+			if _, ok := cfg.Service(name); ok {
+				continue
+			}
+			if usage.Blocked {
+				fmt.Printf("Service %s was removed. Unblocking client %s...\n", name, client)
+				if err := a.unblock(ctx, client, config.Service{Name: name}); err != nil {
+					continue
+				}
+			}
+			delete(client.Services, name)
+		}
+	}
+	for _, person := range a.stats.People {
+		for name := range person.Services {
+			if _, ok := cfg.Service(name); !ok {
+				delete(person.Services, name)
+			}
+		}
+	}
+}
+
+// cleanupRemovedDomains deletes deny rules of domains no service uses anymore.
+// Callers must hold a.mu.
+func (a *App) cleanupRemovedDomains(ctx context.Context, old, cfg config.Config) {
+	var current []domain.Pattern
+	for _, service := range cfg.Services {
+		current = append(current, service.Patterns...)
+	}
+
+	for _, service := range old.Services {
+		for _, pattern := range service.Patterns {
+			if slices.Contains(current, pattern) {
+				continue
+			}
+			fmt.Printf("Domain %s was removed. Deleting it from Pi-hole...\n", pattern)
+			if err := a.client.RemoveDomain(ctx, pattern); err != nil {
+				fmt.Printf("Failed to remove domain %s: %v\n", pattern, err)
+			}
+		}
+	}
+}
+
+// blockAddedDomains adds new service domains to the block group of the
+// service, so clients that are blocked already are blocked for them as well.
+// Callers must hold a.mu.
+func (a *App) blockAddedDomains(ctx context.Context, old, cfg config.Config) {
+	for _, service := range cfg.Services {
+		previous, _ := old.Service(service.Name)
+		var added []domain.Pattern
+		for _, pattern := range service.Patterns {
+			if !slices.Contains(previous.Patterns, pattern) {
+				added = append(added, pattern)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+
+		fmt.Printf("Adding new %s domains to its block group...\n", service.Name)
+		if err := a.client.AddServiceDomains(ctx, service.Name, added); err != nil {
+			fmt.Printf("Failed to add new %s domains: %v\n", service.Name, err)
+		}
+	}
+}
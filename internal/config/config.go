@@ -1,65 +1,90 @@
 package config
 
 import (
-	"os"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	PiholeAddress       string
-	CheckInternal       time.Duration
-	DaylyWatchingLimit  time.Duration
-	DomainsToCheck      []string
-	Password            string
-	TelegramToken       string
-	TelegramChatID      string
-	SpeakerURL          string
-	SpeakerLanguage     string
-	NearLimitMessage    string
-	LimitReachedMessage string
-	ApiPort             string
+	PiholeAddress   string
+	Password        string
+	TOTPSecret      string // base32, for Pi-hole accounts with two-factor authentication
+	CheckInternal   time.Duration
+	QueryPageSize   int
+	MaxQueryPages   int
+	CleanupAfter    int // days until Pi-hole groups of unseen devices are deleted, 0 never
+	Retries         int // of failed Pi-hole requests that are safe to repeat
+	RetryBackoff    time.Duration
+	Services        []Service
+	DomainListsDir  string
+	TelegramToken   string
+	TelegramChatID  string
+	SpeakerURL      string
+	SpeakerLanguage string
+	ApiPort         string
+	StateBackend    string
+	StatePath       string
+	Profiles        map[string]Profile
+	ClientProfiles  map[string]string
+	People          map[string]Person
+	DefaultProfile  string
+	Location        *time.Location
 }
 
-func NewConfig() Config {
-	return Config{
-		PiholeAddress:      os.Getenv("PIHOLE_ADDRESS"),
-		CheckInternal:      parseDurationEnv("CHECK_INTERNAL", 1*time.Minute),
-		DaylyWatchingLimit: parseDurationEnv("DAYLY_WATCHING_LIMIT", 1*time.Hour),
-		DomainsToCheck: []string{
-			"*youtube*",
-			"*googlevideo*",
-			"*.ggpht.com",
-			"*.youtu.be",
-			"*.yt.be",
-			"*.ytimg.com",
-			"*googleusercontent.com",
-		},
-		Password:            os.Getenv("PIHOLE_PASSWORD"),
-		TelegramToken:       os.Getenv("TELEGRAM_BOT_TOKEN"),
-		TelegramChatID:      os.Getenv("TELEGRAM_CHAT_ID"),
-		SpeakerURL:          os.Getenv("SPEAKER_URL"), // e.g. http://192.168.1.50:8080
-		SpeakerLanguage:     getEnv("SPEAKER_LANGUAGE", "en"),
-		NearLimitMessage:    getEnv("SPEAKER_NEAR_LIMIT_MESSAGE", "Less than five minutes remaining. Wrap it up."),
-		LimitReachedMessage: getEnv("SPEAKER_LIMIT_REACHED_MESSAGE", "Time's up. Viewing is now blocked."),
-		ApiPort:             getEnv("API_PORT", "8081"),
+// Load builds the config from the built-in defaults, the config file (if
+// path is not empty) and the environment, each overriding the previous one.
+// Every problem found on the way is returned at once as a *ValidationError.
+func Load(path string) (Config, error) {
+	cfg := defaultConfig()
+	var errs errorList
+	if path != "" {
+		errs = append(errs, loadFile(path, &cfg)...)
 	}
-}
+	errs = append(errs, applyEnv(&cfg)...)
+	errs = append(errs, cfg.buildPatterns()...)
 
-func getEnv(key, fallback string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	// Secrets are often shown in groups of four, lower case or padded
+	cfg.TOTPSecret = strings.TrimRight(strings.ToUpper(strings.ReplaceAll(cfg.TOTPSecret, " ", "")), "=")
+	if cfg.StatePath == "" {
+		cfg.StatePath = defaultStatePath(cfg.StateBackend)
 	}
-	return fallback
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return Config{}, &ValidationError{Errors: errs}
+	}
+	return cfg, nil
 }
 
-func parseDurationEnv(key string, defaultDuration time.Duration) time.Duration {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultDuration
+func defaultConfig() Config {
+	return Config{
+		CheckInternal:   1 * time.Minute,
+		QueryPageSize:   1000,
+		MaxQueryPages:   50,
+		CleanupAfter:    30,
+		Retries:         3,
+		RetryBackoff:    500 * time.Millisecond,
+		Services:        []Service{newService("youtube", builtinServices["youtube"])},
+		DomainListsDir:  "domain-lists",
+		SpeakerLanguage: "en",
+		ApiPort:         "8081",
+		StateBackend:    "json", // json, sqlite or none
+		Profiles:        map[string]Profile{DefaultProfileName: defaultProfile()},
+		ClientProfiles:  map[string]string{},
+		People:          map[string]Person{},
+		DefaultProfile:  DefaultProfileName,
+		Location:        time.Local,
 	}
-	duration, err := time.ParseDuration(value)
-	if err != nil {
-		return defaultDuration
+}
+
+// Now returns the current time in the configured timezone.
+func (c Config) Now() time.Time {
+	return time.Now().In(c.Location)
+}
+
+func defaultStatePath(backend string) string {
+	if backend == "sqlite" {
+		return "data/state.db"
 	}
-	return duration
+	return "data/state.json"
 }
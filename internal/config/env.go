@@ -0,0 +1,401 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profileEnv holds the variable names of a profile. The default profile keeps
+// the historical names, other profiles use PROFILE_<NAME>_*.
+type profileEnv struct {
+	Limit                string
+	WarningThreshold     string
+	NearLimitMessage     string
+	LimitReachedMessage  string
+	Enforce              string
+	AllowedWindows       string
+	CurfewWarning        string
+	CurfewWarningMessage string
+	CurfewReachedMessage string
+	ServicePrefix        string
+}
+
+var defaultProfileEnv = profileEnv{
+	Limit:                "DAYLY_WATCHING_LIMIT",
+	WarningThreshold:     "WARNING_THRESHOLD",
+	NearLimitMessage:     "SPEAKER_NEAR_LIMIT_MESSAGE",
+	LimitReachedMessage:  "SPEAKER_LIMIT_REACHED_MESSAGE",
+	AllowedWindows:       "ALLOWED_WINDOWS",
+	CurfewWarning:        "CURFEW_WARNING",
+	CurfewWarningMessage: "SPEAKER_CURFEW_WARNING_MESSAGE",
+	CurfewReachedMessage: "SPEAKER_CURFEW_REACHED_MESSAGE",
+	ServicePrefix:        "SERVICE_",
+}
+
+func namedProfileEnv(name string) profileEnv {
+	prefix := "PROFILE_" + envName(name) + "_"
+	return profileEnv{
+		Limit:                prefix + "LIMIT",
+		WarningThreshold:     prefix + "WARNING_THRESHOLD",
+		NearLimitMessage:     prefix + "NEAR_LIMIT_MESSAGE",
+		LimitReachedMessage:  prefix + "LIMIT_REACHED_MESSAGE",
+		Enforce:              prefix + "ENFORCE",
+		AllowedWindows:       prefix + "ALLOWED_WINDOWS",
+		CurfewWarning:        prefix + "CURFEW_WARNING",
+		CurfewWarningMessage: prefix + "CURFEW_WARNING_MESSAGE",
+		CurfewReachedMessage: prefix + "CURFEW_REACHED_MESSAGE",
+		ServicePrefix:        prefix + "SERVICE_",
+	}
+}
+
+// envReader reads overrides from the environment and collects every value
+// that cannot be parsed instead of silently falling back to the default.
+type envReader struct {
+	errs errorList
+}
+
+// applyEnv overrides the config with every variable that is set.
+func applyEnv(cfg *Config) errorList {
+	env := &envReader{}
+	cfg.PiholeAddress = getEnv("PIHOLE_ADDRESS", cfg.PiholeAddress)
+	cfg.Password = getEnv("PIHOLE_PASSWORD", cfg.Password)
+	cfg.TOTPSecret = getEnv("PIHOLE_TOTP_SECRET", cfg.TOTPSecret)
+	cfg.QueryPageSize = env.parseIntEnv("PIHOLE_PAGE_SIZE", cfg.QueryPageSize)
+	cfg.MaxQueryPages = env.parseIntEnv("PIHOLE_MAX_PAGES", cfg.MaxQueryPages)
+	cfg.CleanupAfter = env.parseIntEnv("PIHOLE_CLEANUP_AFTER_DAYS", cfg.CleanupAfter)
+	cfg.Retries = env.parseIntEnv("PIHOLE_RETRIES", cfg.Retries)
+	cfg.RetryBackoff = env.parseDurationEnv("PIHOLE_RETRY_BACKOFF", cfg.RetryBackoff)
+	cfg.CheckInternal = env.parseDurationEnv("CHECK_INTERNAL", cfg.CheckInternal)
+	cfg.TelegramToken = getEnv("TELEGRAM_BOT_TOKEN", cfg.TelegramToken)
+	cfg.TelegramChatID = getEnv("TELEGRAM_CHAT_ID", cfg.TelegramChatID)
+	cfg.SpeakerURL = getEnv("SPEAKER_URL", cfg.SpeakerURL) // e.g. http://192.168.1.50:8080
+	cfg.SpeakerLanguage = getEnv("SPEAKER_LANGUAGE", cfg.SpeakerLanguage)
+	cfg.ApiPort = getEnv("API_PORT", cfg.ApiPort)
+	cfg.StateBackend = getEnv("STATE_BACKEND", cfg.StateBackend)
+	cfg.StatePath = getEnv("STATE_PATH", cfg.StatePath)
+	cfg.DefaultProfile = getEnv("DEFAULT_PROFILE", cfg.DefaultProfile)
+	cfg.DomainListsDir = getEnv("DOMAIN_LISTS_DIR", cfg.DomainListsDir)
+	cfg.Location = env.parseLocationEnv("TIMEZONE", cfg.Location)
+
+	env.applyServicesEnv(cfg)
+	env.applyProfilesEnv(cfg)
+
+	for client, profile := range env.parseClientProfiles("CLIENT_PROFILES") {
+		cfg.ClientProfiles[NormalizeClient(client)] = profile
+	}
+	applyPeopleEnv(cfg)
+	return env.errs
+}
+
+// applyServicesEnv reads SERVICES, SERVICE_<NAME>_DOMAINS and
+// SERVICE_<NAME>_DOMAIN_LIST. Built-in and file services only need these to
+// change their domains.
+func (e *envReader) applyServicesEnv(cfg *Config) {
+	names := splitList(os.Getenv("SERVICES"))
+	if len(names) == 0 {
+		for _, service := range cfg.Services {
+			names = append(names, service.Name)
+		}
+	}
+
+	var services []Service
+	for _, name := range names {
+		service, ok := cfg.Service(name)
+		if !ok {
+			service = newService(name, nil)
+		}
+		prefix := "SERVICE_" + envName(name)
+		if domains := splitList(os.Getenv(prefix + "_DOMAINS")); len(domains) > 0 {
+			service.Domains = domains
+		}
+		service.DomainList = getEnv(prefix+"_DOMAIN_LIST", service.DomainList)
+		e.applyUsageEnv(&service.Usage, prefix)
+		e.applyNoiseEnv(&service.Noise, prefix)
+		if !ok && len(service.Domains) == 0 && service.DomainList == "" {
+			service.Domains = builtinServices[name]
+		}
+		if len(service.Domains) == 0 && service.DomainList == "" {
+			e.errs.add(prefix+"_DOMAINS", fmt.Errorf("no domains or domain list for service %q", name))
+			continue
+		}
+		services = append(services, service)
+	}
+	cfg.Services = services
+}
+
+// applyUsageEnv reads <prefix>_ESTIMATOR, <prefix>_WINDOW,
+// <prefix>_MIN_QUERIES, <prefix>_WEIGHTS and <prefix>_DEFAULT_WEIGHT.
+func (e *envReader) applyUsageEnv(usage *Usage, prefix string) {
+	usage.Estimator = getEnv(prefix+"_ESTIMATOR", usage.Estimator)
+	usage.Window = e.parseDurationEnv(prefix+"_WINDOW", usage.Window)
+	usage.MinQueries = e.parseIntEnv(prefix+"_MIN_QUERIES", usage.MinQueries)
+	usage.DefaultWeight = e.parseFloatEnv(prefix+"_DEFAULT_WEIGHT", usage.DefaultWeight)
+
+	// "*googlevideo*=1,domain:ytimg.com=0"
+	key := prefix + "_WEIGHTS"
+	if value := os.Getenv(key); value != "" {
+		usage.Weights = map[string]float64{}
+		for _, item := range splitList(value) {
+			pattern, weight, ok := strings.Cut(item, "=")
+			w, err := strconv.ParseFloat(strings.TrimSpace(weight), 64)
+			if !ok || err != nil {
+				e.errs.add(key, fmt.Errorf("malformed entry %q, expected domain=weight", item))
+				continue
+			}
+			usage.Weights[strings.TrimSpace(pattern)] = w
+		}
+	}
+}
+
+// applyNoiseEnv reads <prefix>_REQUIRE_DOMAINS, <prefix>_IGNORE_TYPES,
+// <prefix>_IGNORE_STATUSES, <prefix>_IGNORE_CACHED, <prefix>_DROP_ISOLATED and
+// <prefix>_NOISE_WINDOW.
+func (e *envReader) applyNoiseEnv(noise *Noise, prefix string) {
+	if domains := splitList(os.Getenv(prefix + "_REQUIRE_DOMAINS")); len(domains) > 0 {
+		noise.RequireDomains = domains
+	}
+	if types := splitList(os.Getenv(prefix + "_IGNORE_TYPES")); len(types) > 0 {
+		noise.IgnoreTypes = types
+	}
+	if statuses := splitList(os.Getenv(prefix + "_IGNORE_STATUSES")); len(statuses) > 0 {
+		noise.IgnoreStatuses = statuses
+	}
+	noise.IgnoreCached = e.parseBoolEnv(prefix+"_IGNORE_CACHED", noise.IgnoreCached)
+	noise.DropIsolated = e.parseBoolEnv(prefix+"_DROP_ISOLATED", noise.DropIsolated)
+	noise.Window = e.parseDurationEnv(prefix+"_NOISE_WINDOW", noise.Window)
+}
+
+// applyProfilesEnv overrides the default profile, the file profiles and the
+// profiles listed in PROFILES. New profiles start as a copy of the default one.
+func (e *envReader) applyProfilesEnv(cfg *Config) {
+	defaults := cfg.Profiles[DefaultProfileName]
+	e.applyProfileEnv(&defaults, defaultProfileEnv, cfg.Services)
+	cfg.Profiles[DefaultProfileName] = defaults
+
+	for _, name := range splitList(os.Getenv("PROFILES")) {
+		if _, ok := cfg.Profiles[name]; !ok {
+			cfg.Profiles[name] = defaults.inherit(name)
+		}
+	}
+
+	for name, profile := range cfg.Profiles {
+		if name == DefaultProfileName {
+			continue
+		}
+		e.applyProfileEnv(&profile, namedProfileEnv(name), cfg.Services)
+		cfg.Profiles[name] = profile
+	}
+}
+
+func (e *envReader) applyProfileEnv(profile *Profile, env profileEnv, services []Service) {
+	e.applyScheduleEnv(&profile.Schedule, env.Limit)
+	profile.WarningThreshold = e.parseDurationEnv(env.WarningThreshold, profile.WarningThreshold)
+	profile.NearLimitMessage = getEnv(env.NearLimitMessage, profile.NearLimitMessage)
+	profile.LimitReachedMessage = getEnv(env.LimitReachedMessage, profile.LimitReachedMessage)
+	if env.Enforce != "" {
+		profile.Enforce = e.parseBoolEnv(env.Enforce, profile.Enforce)
+	}
+	if value := os.Getenv(env.AllowedWindows); value != "" {
+		windows, err := parseWindows(value)
+		if err != nil {
+			e.errs.add(env.AllowedWindows, err)
+		} else {
+			profile.AllowedWindows = windows
+		}
+	}
+	profile.CurfewWarning = e.parseDurationEnv(env.CurfewWarning, profile.CurfewWarning)
+	profile.CurfewWarningMessage = getEnv(env.CurfewWarningMessage, profile.CurfewWarningMessage)
+	profile.CurfewReachedMessage = getEnv(env.CurfewReachedMessage, profile.CurfewReachedMessage)
+
+	// Per-service limits such as SERVICE_YOUTUBE_LIMIT. Services without
+	// their own limit use the profile limit.
+	for _, service := range services {
+		key := env.ServicePrefix + envName(service.Name) + "_LIMIT"
+		if !hasEnvPrefix(key) {
+			continue
+		}
+		schedule, ok := profile.ServiceSchedules[service.Name]
+		if !ok {
+			schedule = Schedule{Default: profile.Schedule.Default}
+		}
+		e.applyScheduleEnv(&schedule, key)
+		if profile.ServiceSchedules == nil {
+			profile.ServiceSchedules = map[string]Schedule{}
+		}
+		profile.ServiceSchedules[service.Name] = schedule
+	}
+}
+
+// applyScheduleEnv reads <prefix>, <prefix>_WEEKDAYS, <prefix>_WEEKEND and
+// <prefix>_MONDAY ... <prefix>_SUNDAY. Setting <prefix> replaces the whole
+// schedule, so inherited day limits do not leak into the new one.
+func (e *envReader) applyScheduleEnv(schedule *Schedule, prefix string) {
+	if os.Getenv(prefix) != "" {
+		*schedule = Schedule{Default: e.parseDurationEnv(prefix, schedule.Default)}
+	}
+	if limit, ok := e.lookupDurationEnv(prefix + "_WEEKDAYS"); ok {
+		schedule.Weekdays = &limit
+	}
+	if limit, ok := e.lookupDurationEnv(prefix + "_WEEKEND"); ok {
+		schedule.Weekend = &limit
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		// 0 is a limit as well: no access on that day
+		if limit, ok := e.lookupDurationEnv(prefix + "_" + strings.ToUpper(day.String())); ok {
+			if schedule.Days == nil {
+				schedule.Days = map[time.Weekday]time.Duration{}
+			}
+			schedule.Days[day] = limit
+		}
+	}
+}
+
+// applyPeopleEnv reads PEOPLE, PERSON_<NAME>_PROFILE and PERSON_<NAME>_DEVICES.
+func applyPeopleEnv(cfg *Config) {
+	for _, name := range splitList(os.Getenv("PEOPLE")) {
+		if _, ok := cfg.People[name]; !ok {
+			cfg.People[name] = Person{Name: name, Profile: DefaultProfileName}
+		}
+	}
+	for name, person := range cfg.People {
+		prefix := "PERSON_" + envName(name) + "_"
+		person.Profile = getEnv(prefix+"PROFILE", person.Profile)
+		if devices := splitList(os.Getenv(prefix + "DEVICES")); len(devices) > 0 {
+			person.Devices = nil
+			for _, device := range devices {
+				person.Devices = append(person.Devices, NormalizeClient(device))
+			}
+		}
+		cfg.People[name] = person
+	}
+}
+
+// parseClientProfiles parses "192.168.1.15=young,aa:bb:cc:dd:ee:ff=parents".
+func (e *envReader) parseClientProfiles(key string) map[string]string {
+	clients := map[string]string{}
+	for _, item := range splitList(os.Getenv(key)) {
+		client, profile, ok := strings.Cut(item, "=")
+		if !ok {
+			e.errs.add(key, fmt.Errorf("malformed entry %q, expected client=profile", item))
+			continue
+		}
+		clients[strings.TrimSpace(client)] = strings.TrimSpace(profile)
+	}
+	return clients
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (e *envReader) parseDurationEnv(key string, defaultDuration time.Duration) time.Duration {
+	if duration, ok := e.lookupDurationEnv(key); ok {
+		return duration
+	}
+	return defaultDuration
+}
+
+// lookupDurationEnv returns the duration of a variable and whether it is set.
+// Invalid durations are reported and count as not set.
+func (e *envReader) lookupDurationEnv(key string) (time.Duration, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		e.errs.add(key, fmt.Errorf("invalid duration %q, expected e.g. 1h30m", value))
+		return 0, false
+	}
+	return duration, true
+}
+
+func (e *envReader) parseIntEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.errs.add(key, fmt.Errorf("invalid number %q", value))
+		return fallback
+	}
+	return n
+}
+
+func (e *envReader) parseFloatEnv(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.errs.add(key, fmt.Errorf("invalid number %q", value))
+		return fallback
+	}
+	return f
+}
+
+func (e *envReader) parseBoolEnv(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.errs.add(key, fmt.Errorf("invalid boolean %q, expected true or false", value))
+		return fallback
+	}
+	return b
+}
+
+func (e *envReader) parseLocationEnv(key string, fallback *time.Location) *time.Location {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	location, err := time.LoadLocation(value)
+	if err != nil {
+		e.errs.add(key, fmt.Errorf("unknown timezone %q", value))
+		return fallback
+	}
+	return location
+}
+
+func hasEnvPrefix(prefix string) bool {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envName turns a name into the form used in variable names: "teen-2" -> "TEEN_2".
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,386 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type fileConfig struct {
+	Pihole         filePihole             `yaml:"pihole"`
+	CheckInterval  string                 `yaml:"check_interval"`
+	Timezone       string                 `yaml:"timezone"`
+	APIPort        string                 `yaml:"api_port"`
+	State          fileState              `yaml:"state"`
+	Notifiers      fileNotifiers          `yaml:"notifiers"`
+	Services       []fileService          `yaml:"services"`
+	DomainListsDir string                 `yaml:"domain_lists_dir"`
+	Profiles       map[string]fileProfile `yaml:"profiles"`
+	Clients        map[string]string      `yaml:"clients"`
+	People         map[string]filePerson  `yaml:"people"`
+	DefaultProfile string                 `yaml:"default_profile"`
+}
+
+type filePihole struct {
+	Address    string `yaml:"address"`
+	Password   string `yaml:"password"`
+	TOTPSecret string `yaml:"totp_secret"`
+	PageSize   int    `yaml:"page_size"`
+	MaxPages   int    `yaml:"max_pages"`
+	Cleanup    *int   `yaml:"cleanup_after_days"` // 0 turns the cleanup off
+	Retries    *int   `yaml:"retries"`            // 0 turns retries off
+	Backoff    string `yaml:"retry_backoff"`
+}
+
+type fileState struct {
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
+}
+
+type fileNotifiers struct {
+	Telegram struct {
+		Token  string `yaml:"token"`
+		ChatID string `yaml:"chat_id"`
+	} `yaml:"telegram"`
+	Speaker struct {
+		URL      string `yaml:"url"`
+		Language string `yaml:"language"`
+	} `yaml:"speaker"`
+}
+
+type fileService struct {
+	Name       string    `yaml:"name"`
+	Domains    []string  `yaml:"domains"`
+	DomainList string    `yaml:"domain_list"`
+	Usage      fileUsage `yaml:"usage"`
+	Noise      fileNoise `yaml:"noise"`
+}
+
+type fileNoise struct {
+	RequireDomains []string `yaml:"require_domains"`
+	IgnoreTypes    []string `yaml:"ignore_types"`
+	IgnoreStatuses []string `yaml:"ignore_statuses"`
+	IgnoreCached   *bool    `yaml:"ignore_cached"`
+	DropIsolated   *bool    `yaml:"drop_isolated"`
+	Window         string   `yaml:"window"`
+}
+
+type fileUsage struct {
+	Estimator     string             `yaml:"estimator"`
+	Window        string             `yaml:"window"`
+	MinQueries    int                `yaml:"min_queries"`
+	Weights       map[string]float64 `yaml:"weights"`
+	DefaultWeight *float64           `yaml:"default_weight"`
+}
+
+type filePerson struct {
+	Profile string   `yaml:"profile"`
+	Devices []string `yaml:"devices"`
+}
+
+type fileProfile struct {
+	Limit                fileSchedule            `yaml:"limit"`
+	Services             map[string]fileSchedule `yaml:"services"`
+	WarningThreshold     string                  `yaml:"warning_threshold"`
+	NearLimitMessage     string                  `yaml:"near_limit_message"`
+	LimitReachedMessage  string                  `yaml:"limit_reached_message"`
+	Enforce              *bool                   `yaml:"enforce"`
+	AllowedWindows       []string                `yaml:"allowed_windows"`
+	CurfewWarning        string                  `yaml:"curfew_warning"`
+	CurfewWarningMessage string                  `yaml:"curfew_warning_message"`
+	CurfewReachedMessage string                  `yaml:"curfew_reached_message"`
+}
+
+// fileSchedule is either a single duration ("1h") or a map of rules
+// ({default: 1h, weekend: 2h, saturday: 3h}).
+type fileSchedule map[string]string
+
+func (s *fileSchedule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = fileSchedule{"default": node.Value}
+		return nil
+	}
+	rules := map[string]string{}
+	if err := node.Decode(&rules); err != nil {
+		return err
+	}
+	*s = rules
+	return nil
+}
+
+// loadFile reads the YAML config file at path on top of cfg.
+func loadFile(path string, cfg *Config) errorList {
+	var errs errorList
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errs.add(path, err)
+		return errs
+	}
+
+	var file fileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		errs.add(path, err)
+		return errs
+	}
+
+	return file.apply(cfg)
+}
+
+func (f fileConfig) apply(cfg *Config) errorList {
+	var errs errorList
+
+	setString(&cfg.PiholeAddress, f.Pihole.Address)
+	setString(&cfg.Password, f.Pihole.Password)
+	setString(&cfg.TOTPSecret, f.Pihole.TOTPSecret)
+	setInt(&cfg.QueryPageSize, f.Pihole.PageSize)
+	setInt(&cfg.MaxQueryPages, f.Pihole.MaxPages)
+	if f.Pihole.Cleanup != nil {
+		cfg.CleanupAfter = *f.Pihole.Cleanup
+	}
+	if f.Pihole.Retries != nil {
+		cfg.Retries = *f.Pihole.Retries
+	}
+	setDuration(&cfg.RetryBackoff, f.Pihole.Backoff, "pihole.retry_backoff", &errs)
+	setDuration(&cfg.CheckInternal, f.CheckInterval, "check_interval", &errs)
+	setString(&cfg.ApiPort, f.APIPort)
+	setString(&cfg.StateBackend, f.State.Backend)
+	setString(&cfg.StatePath, f.State.Path)
+	setString(&cfg.TelegramToken, f.Notifiers.Telegram.Token)
+	setString(&cfg.TelegramChatID, f.Notifiers.Telegram.ChatID)
+	setString(&cfg.SpeakerURL, f.Notifiers.Speaker.URL)
+	setString(&cfg.SpeakerLanguage, f.Notifiers.Speaker.Language)
+	setString(&cfg.DefaultProfile, f.DefaultProfile)
+	setString(&cfg.DomainListsDir, f.DomainListsDir)
+
+	if f.Timezone != "" {
+		location, err := time.LoadLocation(f.Timezone)
+		if err != nil {
+			errs.add("timezone", err)
+		} else {
+			cfg.Location = location
+		}
+	}
+
+	if len(f.Services) > 0 {
+		cfg.Services = nil
+		for i, service := range f.Services {
+			key := fmt.Sprintf("services[%d]", i)
+			if service.Name == "" {
+				errs.add(key+".name", errors.New("is required"))
+				continue
+			}
+			if len(service.Domains) == 0 && service.DomainList == "" {
+				service.Domains = builtinServices[service.Name]
+			}
+			if len(service.Domains) == 0 && service.DomainList == "" {
+				errs.add(key+".domains", fmt.Errorf("no domains or domain_list for service %q", service.Name))
+				continue
+			}
+			s := newService(service.Name, service.Domains)
+			s.DomainList = service.DomainList
+			service.Usage.apply(&s.Usage, key+".usage", &errs)
+			service.Noise.apply(&s.Noise, key+".noise", &errs)
+			cfg.Services = append(cfg.Services, s)
+		}
+	}
+
+	// The default profile goes first so the others can inherit from it
+	defaults := cfg.Profiles[DefaultProfileName]
+	if profile, ok := f.Profiles[DefaultProfileName]; ok {
+		profile.apply(&defaults, "profiles."+DefaultProfileName, cfg.Services, &errs)
+		cfg.Profiles[DefaultProfileName] = defaults
+	}
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == DefaultProfileName {
+			continue
+		}
+		if name == NoProfile {
+			errs.add("profiles."+name, fmt.Errorf("%q is reserved", NoProfile))
+			continue
+		}
+		profile := defaults.inherit(name)
+		f.Profiles[name].apply(&profile, "profiles."+name, cfg.Services, &errs)
+		cfg.Profiles[name] = profile
+	}
+
+	for client, profile := range f.Clients {
+		cfg.ClientProfiles[NormalizeClient(client)] = profile
+	}
+	for name, person := range f.People {
+		p := Person{Name: name, Profile: person.Profile}
+		if p.Profile == "" {
+			p.Profile = DefaultProfileName
+		}
+		for _, device := range person.Devices {
+			p.Devices = append(p.Devices, NormalizeClient(device))
+		}
+		cfg.People[name] = p
+	}
+
+	return errs
+}
+
+func (f fileProfile) apply(profile *Profile, key string, services []Service, errs *errorList) {
+	if f.Limit != nil {
+		if schedule, ok := f.Limit.schedule(key+".limit", profile.Schedule.Default, errs); ok {
+			profile.Schedule = schedule
+		}
+	}
+	for name, limit := range f.Services {
+		serviceKey := key + ".services." + name
+		if !hasService(services, name) {
+			errs.add(serviceKey, errors.New("unknown service"))
+			continue
+		}
+		if schedule, ok := limit.schedule(serviceKey, profile.Schedule.Default, errs); ok {
+			if profile.ServiceSchedules == nil {
+				profile.ServiceSchedules = map[string]Schedule{}
+			}
+			profile.ServiceSchedules[name] = schedule
+		}
+	}
+	setDuration(&profile.WarningThreshold, f.WarningThreshold, key+".warning_threshold", errs)
+	setString(&profile.NearLimitMessage, f.NearLimitMessage)
+	setString(&profile.LimitReachedMessage, f.LimitReachedMessage)
+	if f.Enforce != nil {
+		profile.Enforce = *f.Enforce
+	}
+	if f.AllowedWindows != nil {
+		profile.AllowedWindows = nil
+		for i, value := range f.AllowedWindows {
+			windows, err := parseWindows(value)
+			if err != nil {
+				errs.add(fmt.Sprintf("%s.allowed_windows[%d]", key, i), err)
+				continue
+			}
+			profile.AllowedWindows = append(profile.AllowedWindows, windows...)
+		}
+	}
+	setDuration(&profile.CurfewWarning, f.CurfewWarning, key+".curfew_warning", errs)
+	setString(&profile.CurfewWarningMessage, f.CurfewWarningMessage)
+	setString(&profile.CurfewReachedMessage, f.CurfewReachedMessage)
+}
+
+func (f fileUsage) apply(usage *Usage, key string, errs *errorList) {
+	setString(&usage.Estimator, f.Estimator)
+	setDuration(&usage.Window, f.Window, key+".window", errs)
+	setInt(&usage.MinQueries, f.MinQueries)
+	if f.Weights != nil {
+		usage.Weights = f.Weights
+	}
+	if f.DefaultWeight != nil {
+		usage.DefaultWeight = *f.DefaultWeight
+	}
+}
+
+func (f fileNoise) apply(noise *Noise, key string, errs *errorList) {
+	if f.RequireDomains != nil {
+		noise.RequireDomains = f.RequireDomains
+	}
+	if f.IgnoreTypes != nil {
+		noise.IgnoreTypes = f.IgnoreTypes
+	}
+	if f.IgnoreStatuses != nil {
+		noise.IgnoreStatuses = f.IgnoreStatuses
+	}
+	if f.IgnoreCached != nil {
+		noise.IgnoreCached = *f.IgnoreCached
+	}
+	if f.DropIsolated != nil {
+		noise.DropIsolated = *f.DropIsolated
+	}
+	setDuration(&noise.Window, f.Window, key+".window", errs)
+}
+
+// schedule returns the limits of the map. Without a default rule the days
+// not listed keep the inherited limit.
+func (s fileSchedule) schedule(key string, inherited time.Duration, errs *errorList) (Schedule, bool) {
+	schedule := Schedule{Default: inherited}
+	ok := true
+	for rule, value := range s {
+		limit, err := time.ParseDuration(value)
+		if err != nil {
+			errs.add(key+"."+rule, fmt.Errorf("invalid duration %q", value))
+			ok = false
+			continue
+		}
+
+		switch rule = strings.ToLower(rule); rule {
+		case "default":
+			schedule.Default = limit
+		case "weekdays":
+			schedule.Weekdays = &limit
+		case "weekend":
+			schedule.Weekend = &limit
+		default:
+			day, found := parseWeekday(rule)
+			if !found {
+				errs.add(key+"."+rule, errors.New("unknown rule, expected default, weekdays, weekend or a day of the week"))
+				ok = false
+				continue
+			}
+			if schedule.Days == nil {
+				schedule.Days = map[time.Weekday]time.Duration{}
+			}
+			schedule.Days[day] = limit
+		}
+	}
+	return schedule, ok
+}
+
+func parseWeekday(value string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), value) {
+			return day, true
+		}
+	}
+	day, ok := weekdays[value]
+	return day, ok
+}
+
+func hasService(services []Service, name string) bool {
+	for _, service := range services {
+		if service.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func setString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+func setInt(field *int, value int) {
+	if value != 0 {
+		*field = value
+	}
+}
+
+func setDuration(field *time.Duration, value, key string, errs *errorList) {
+	if value == "" {
+		return
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		errs.add(key, fmt.Errorf("invalid duration %q", value))
+		return
+	}
+	*field = duration
+}
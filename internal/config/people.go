@@ -0,0 +1,62 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// Person shares one budget between several devices, e.g. a child with an
+// iPad, a phone and the living room TV.
+type Person struct {
+	Name    string
+	Profile string
+	Devices []string // MAC addresses, host names or IP addresses
+}
+
+// PersonFor returns the person owning the client, matched by MAC address,
+// host name or any of its IP addresses. Empty values are skipped.
+func (c Config) PersonFor(mac, hostname string, ips ...string) (Person, bool) {
+	keys := append([]string{mac, hostname}, ips...)
+	for _, name := range sortedKeys(c.People) {
+		person := c.People[name]
+		for _, device := range person.Devices {
+			for _, key := range keys {
+				if key != "" && NormalizeClient(key) == device {
+					return person, true
+				}
+			}
+		}
+	}
+	return Person{}, false
+}
+
+func (p Person) validate(key string, c Config, owners map[string]string) errorList {
+	var errs errorList
+
+	if _, ok := c.Profiles[p.Profile]; !ok {
+		errs.add(key+".profile", fmt.Errorf("unknown profile %q", p.Profile))
+	}
+	if len(p.Devices) == 0 {
+		errs.add(key+".devices", errors.New("is required"))
+	}
+	for _, device := range p.Devices {
+		if err := ValidateClient(device); err != nil {
+			errs.add(key+".devices", err)
+			continue
+		}
+		if _, err := netip.ParsePrefix(device); err == nil {
+			errs.add(key+".devices", fmt.Errorf("%q is a subnet, not a device", device))
+			continue
+		}
+		if owner, ok := owners[device]; ok {
+			errs.add(key+".devices", fmt.Errorf("%q already belongs to %s", device, owner))
+			continue
+		}
+		owners[device] = p.Name
+		if _, ok := c.ClientProfiles[device]; ok {
+			errs.add(key+".devices", fmt.Errorf("%q is listed in clients as well, the profile of the person applies", device))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultProfileName = "default"
+	// NoProfile as DEFAULT_PROFILE makes the app ignore clients without a profile
+	NoProfile = "none"
+)
+
+// Profile describes the limits applied to a group of clients.
+type Profile struct {
+	Name                string
+	Schedule            Schedule
+	WarningThreshold    time.Duration
+	NearLimitMessage    string
+	LimitReachedMessage string
+	Enforce             bool
+
+	// ServiceSchedules override Schedule for single services
+	ServiceSchedules map[string]Schedule
+
+	// AllowedWindows limit access to certain hours regardless of the remaining budget
+	AllowedWindows       []Window
+	CurfewWarning        time.Duration
+	CurfewWarningMessage string
+	CurfewReachedMessage string
+}
+
+func defaultProfile() Profile {
+	return Profile{
+		Name:                 DefaultProfileName,
+		Schedule:             Schedule{Default: 1 * time.Hour},
+		WarningThreshold:     5 * time.Minute,
+		NearLimitMessage:     "Less than five minutes remaining. Wrap it up.",
+		LimitReachedMessage:  "Time's up. Viewing is now blocked.",
+		Enforce:              true,
+		ServiceSchedules:     map[string]Schedule{},
+		CurfewWarning:        10 * time.Minute,
+		CurfewWarningMessage: "Bedtime in {{.Minutes}} minutes.",
+		CurfewReachedMessage: "It's bedtime. Viewing is now blocked.",
+	}
+}
+
+// inherit returns a copy of the profile under a new name. Profiles start as
+// a copy of the default profile and override what they need.
+func (p Profile) inherit(name string) Profile {
+	p.Name = name
+	p.ServiceSchedules = maps.Clone(p.ServiceSchedules)
+	p.AllowedWindows = slices.Clone(p.AllowedWindows)
+	return p
+}
+
+// ScheduleFor returns the limits of the service for this profile.
+func (p Profile) ScheduleFor(service string) Schedule {
+	if schedule, ok := p.ServiceSchedules[service]; ok {
+		return schedule
+	}
+	return p.Schedule
+}
+
+// ProfileFor returns the profile assigned to the client: the profile of the
+// person owning it, or the one looked up by MAC address, host name, IP
+// addresses and subnets in that order. A dual-stack device has several IP
+// addresses, a match of any of them counts. Empty values are skipped. The
+// second value is false when the client has no profile and should be ignored.
+func (c Config) ProfileFor(mac, hostname string, ips ...string) (Profile, bool) {
+	if person, ok := c.PersonFor(mac, hostname, ips...); ok {
+		profile, ok := c.Profiles[person.Profile]
+		return profile, ok
+	}
+
+	name, ok := "", false
+	for _, key := range append([]string{mac, hostname}, ips...) {
+		if key == "" {
+			continue
+		}
+		if name, ok = c.ClientProfiles[NormalizeClient(key)]; ok {
+			break
+		}
+	}
+	if !ok {
+		name, ok = c.subnetProfile(ips)
+	}
+	if !ok {
+		name = c.DefaultProfile
+	}
+	if name == NoProfile {
+		return Profile{}, false
+	}
+	profile, ok := c.Profiles[name]
+	return profile, ok
+}
+
+// NormalizeClient returns the canonical form of a client key: MAC addresses
+// as lowercase colon separated bytes, IP addresses and subnets in their
+// shortest form ("2001:DB8:0::1" -> "2001:db8::1", "::ffff:192.168.1.15" ->
+// "192.168.1.15"), host names in lowercase.
+func NormalizeClient(client string) string {
+	if mac, err := net.ParseMAC(client); err == nil {
+		return mac.String()
+	}
+	if addr, err := netip.ParseAddr(client); err == nil {
+		return addr.Unmap().String()
+	}
+	if prefix, err := netip.ParsePrefix(client); err == nil {
+		return prefix.Masked().String()
+	}
+	return strings.ToLower(client)
+}
+
+// subnetProfile returns the profile of the most specific subnet containing
+// one of the addresses.
+func (c Config) subnetProfile(ips []string) (string, bool) {
+	name, bits := "", -1
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+		for _, client := range sortedKeys(c.ClientProfiles) {
+			prefix, err := netip.ParsePrefix(client)
+			if err != nil || !prefix.Contains(addr) {
+				continue
+			}
+			if prefix.Bits() > bits {
+				name, bits = c.ClientProfiles[client], prefix.Bits()
+			}
+		}
+	}
+	return name, bits >= 0
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule holds the daily limits of a profile. The most specific rule wins:
+// a single day, then weekdays/weekend, then the default. Weekdays and Weekend
+// are nil when not set, a limit of 0 blocks the whole day.
+type Schedule struct {
+	Default  time.Duration
+	Weekdays *time.Duration
+	Weekend  *time.Duration
+	Days     map[time.Weekday]time.Duration
+}
+
+// LimitFor returns the limit in force at t and the name of the rule it comes from.
+func (s Schedule) LimitFor(t time.Time) (time.Duration, string) {
+	day := t.Weekday()
+	if limit, ok := s.Days[day]; ok {
+		return limit, strings.ToLower(day.String())
+	}
+	if day == time.Saturday || day == time.Sunday {
+		if s.Weekend != nil {
+			return *s.Weekend, "weekend"
+		}
+	} else if s.Weekdays != nil {
+		return *s.Weekdays, "weekdays"
+	}
+	return s.Default, "default"
+}
+
+// Window is a time range during which access is allowed. Start and End are
+// offsets from midnight. A window without days applies to every day.
+type Window struct {
+	Days  []time.Weekday
+	Start time.Duration
+	End   time.Duration
+}
+
+func (w Window) appliesTo(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedAt reports whether t falls into one of the windows and when the
+// current stretch of allowed time ends. Without windows access is always allowed.
+// Windows are wall clock times, so they do not shift on DST change days.
+func AllowedAt(windows []Window, t time.Time) (bool, time.Time) {
+	if len(windows) == 0 {
+		return true, time.Time{}
+	}
+
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	end, allowed := extendWindows(windows, day.Weekday(), clock)
+	if !allowed {
+		return false, time.Time{}
+	}
+	// A stretch until midnight goes on with the windows of the next day, so
+	// "sat 20:00-24:00; sun 00:00-01:00" closes at 01:00 on Sunday
+	for range 7 {
+		if end < 24*time.Hour {
+			break
+		}
+		next := day.AddDate(0, 0, 1)
+		nextEnd, ok := extendWindows(windows, next.Weekday(), 0)
+		if !ok {
+			break
+		}
+		day, end = next, nextEnd
+	}
+	return true, time.Date(day.Year(), day.Month(), day.Day(), int(end/time.Hour), int(end%time.Hour/time.Minute), 0, 0, day.Location())
+}
+
+// extendWindows returns the end of the windows of the day that cover offset,
+// following back-to-back windows so 15:00-18:00 and 18:00-20:00 close at 20:00.
+func extendWindows(windows []Window, day time.Weekday, offset time.Duration) (time.Duration, bool) {
+	allowed := false
+	end := offset
+	for extended := true; extended; {
+		extended = false
+		for _, w := range windows {
+			if w.appliesTo(day) && w.Start <= end && end < w.End {
+				allowed = true
+				end = w.End
+				extended = true
+			}
+		}
+	}
+	return end, allowed
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseWindows parses "mon-fri 15:00-20:00; sat,sun 09:00-21:00". The day
+// part is optional and also accepts "weekdays" and "weekend".
+func parseWindows(value string) ([]Window, error) {
+	var windows []Window
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		fields := strings.Fields(item)
+		var window Window
+		var err error
+		switch len(fields) {
+		case 1:
+		case 2:
+			if window.Days, err = parseDays(fields[0]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("invalid window %q", item)
+		}
+
+		start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid time range in window %q", item)
+		}
+		if window.Start, err = parseClock(start); err != nil {
+			return nil, err
+		}
+		if window.End, err = parseClock(end); err != nil {
+			return nil, err
+		}
+		if window.Start >= window.End {
+			return nil, fmt.Errorf("window %q ends before it starts", item)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+func parseDays(value string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, part := range strings.Split(strings.ToLower(value), ",") {
+		switch part {
+		case "weekdays":
+			part = "mon-fri"
+		case "weekend":
+			part = "sat-sun"
+		}
+
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", from)
+		}
+		if !isRange {
+			days = append(days, first)
+			continue
+		}
+		last, ok := weekdays[to]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", to)
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses "15:04" into an offset from midnight. "24:00" is allowed as a window end.
+func parseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
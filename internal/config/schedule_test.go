@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAllowedAt(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	local := func(value string) time.Time {
+		t.Helper()
+		tm, err := time.ParseInLocation("2006-01-02 15:04", value, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		name    string
+		windows string
+		at      string
+		allowed bool
+		closes  string
+	}{
+		{name: "inside", windows: "15:00-20:00", at: "2026-10-14 16:00", allowed: true, closes: "2026-10-14 20:00"},
+		{name: "before", windows: "15:00-20:00", at: "2026-10-14 14:59", allowed: false},
+		{name: "at the end", windows: "15:00-20:00", at: "2026-10-14 20:00", allowed: false},
+		{name: "back-to-back", windows: "15:00-18:00; 18:00-20:00", at: "2026-10-14 16:00", allowed: true, closes: "2026-10-14 20:00"},
+		{name: "other day", windows: "sat 09:00-21:00", at: "2026-10-14 10:00", allowed: false},
+		// Clocks go back from 03:00 to 02:00 on 2026-10-25 and forward from
+		// 02:00 to 03:00 on 2026-03-29: the windows stay at wall clock times
+		{name: "DST end, before", windows: "15:00-20:00", at: "2026-10-25 14:30", allowed: false},
+		{name: "DST end, inside", windows: "15:00-20:00", at: "2026-10-25 19:30", allowed: true, closes: "2026-10-25 20:00"},
+		{name: "DST start, before", windows: "15:00-20:00", at: "2026-03-29 14:30", allowed: false},
+		{name: "DST start, inside", windows: "15:00-20:00", at: "2026-03-29 19:30", allowed: true, closes: "2026-03-29 20:00"},
+		{name: "across midnight", windows: "sat 20:00-24:00; sun 00:00-01:00", at: "2026-10-17 23:50", allowed: true, closes: "2026-10-18 01:00"},
+		{name: "after midnight", windows: "sat 20:00-24:00; sun 00:00-01:00", at: "2026-10-18 00:30", allowed: true, closes: "2026-10-18 01:00"},
+		{name: "until midnight", windows: "sat 20:00-24:00", at: "2026-10-17 23:50", allowed: true, closes: "2026-10-18 00:00"},
+		{name: "across two midnights", windows: "sat,sun 00:00-24:00; mon 00:00-08:00", at: "2026-10-17 12:00", allowed: true, closes: "2026-10-19 08:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := parseWindows(tt.windows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			allowed, closes := AllowedAt(windows, local(tt.at))
+			if allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v", allowed, tt.allowed)
+			}
+			if tt.closes == "" {
+				if !closes.IsZero() {
+					t.Errorf("closes = %v, want zero", closes)
+				}
+			} else if !closes.Equal(local(tt.closes)) {
+				t.Errorf("closes = %v, want %v", closes, local(tt.closes))
+			}
+		})
+	}
+}
+
+func TestScheduleLimits(t *testing.T) {
+	t.Setenv("PIHOLE_ADDRESS", "http://pi.hole")
+	t.Setenv("PROFILE_TEEN_LIMIT_SUNDAY", "0")
+	t.Setenv("PROFILE_TEEN_LIMIT_WEEKDAYS", "0s")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+profiles:
+  default:
+    limit: 1h
+  young:
+    limit:
+      saturday: 3h
+  weekend:
+    limit:
+      default: 30m
+      weekend: 0
+  teen:
+    limit:
+      default: 2h
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	sunday := saturday.AddDate(0, 0, 1)
+	monday := saturday.AddDate(0, 0, 2)
+	tests := []struct {
+		profile string
+		at      time.Time
+		limit   time.Duration
+		rule    string
+	}{
+		// Days not in the map keep the limit of the default profile
+		{profile: "young", at: saturday, limit: 3 * time.Hour, rule: "saturday"},
+		{profile: "young", at: monday, limit: time.Hour, rule: "default"},
+		{profile: "weekend", at: sunday, limit: 0, rule: "weekend"},
+		{profile: "weekend", at: monday, limit: 30 * time.Minute, rule: "default"},
+		// 0 in the environment is a limit, not an unset variable
+		{profile: "teen", at: saturday, limit: 2 * time.Hour, rule: "default"},
+		{profile: "teen", at: sunday, limit: 0, rule: "sunday"},
+		{profile: "teen", at: monday, limit: 0, rule: "weekdays"},
+	}
+	for _, tt := range tests {
+		limit, rule := cfg.Profiles[tt.profile].Schedule.LimitFor(tt.at)
+		if limit != tt.limit || rule != tt.rule {
+			t.Errorf("%s on %s: limit %v (%s), want %v (%s)", tt.profile, tt.at.Weekday(), limit, rule, tt.limit, tt.rule)
+		}
+	}
+}
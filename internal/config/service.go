@@ -0,0 +1,204 @@
+package config
+
+import (
+	"slices"
+	"time"
+
+	"github.com/vladikamira/pihole-parental-control/internal/domain"
+	"github.com/vladikamira/pihole-parental-control/internal/domainlist"
+)
+
+// Service is a named group of domains with its own budget and block group.
+// Domains are patterns from the config, DomainList references a v2fly domain
+// list in DomainListsDir. Patterns holds both and is built by Load.
+type Service struct {
+	Name       string
+	Domains    []string
+	DomainList string
+	Patterns   []domain.Pattern
+	Usage      Usage
+	Noise      Noise
+}
+
+// Noise drops queries that do not mean somebody is watching before they are
+// counted. Queries Pi-hole blocked are always dropped, cache hits with
+// IgnoreCached, and queries of a matching type or status right away. With
+// RequireDomains a query only counts if a query for one of these domains is
+// within Window of it, with DropIsolated only if any other query is. Such
+// queries are held back until that is known, at most Window.
+type Noise struct {
+	RequireDomains  []string
+	RequirePatterns []domain.Pattern // RequireDomains parsed by Load
+	IgnoreTypes     []string
+	IgnoreStatuses  []string
+	IgnoreCached    bool
+	DropIsolated    bool
+	Window          time.Duration // defaults to the usage window
+}
+
+// Pi-hole query types and statuses, see the /api/queries documentation.
+var (
+	queryTypes    = []string{"A", "AAAA", "ANY", "SRV", "SOA", "PTR", "TXT", "NAPTR", "MX", "DS", "RRSIG", "DNSKEY", "NS", "SVCB", "HTTPS", "OTHER"}
+	queryStatuses = []string{"UNKNOWN", "GRAVITY", "FORWARDED", "CACHE", "REGEX", "DENYLIST", "EXTERNAL_BLOCKED_IP", "EXTERNAL_BLOCKED_NULL", "EXTERNAL_BLOCKED_NXRA", "GRAVITY_CNAME", "REGEX_CNAME", "DENYLIST_CNAME", "RETRIED", "RETRIED_DNSSEC", "IN_PROGRESS", "DBBUSY", "SPECIAL_DOMAIN", "CACHE_STALE", "EXTERNAL_BLOCKED_EDE15"}
+)
+
+// RequiresPlayback reports whether queries need a RequireDomains query nearby.
+func (n Noise) RequiresPlayback() bool {
+	return len(n.RequirePatterns) > 0
+}
+
+// Playback reports whether the domain name matches RequireDomains.
+func (n Noise) Playback(name string) bool {
+	for _, pattern := range n.RequirePatterns {
+		if pattern.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Usage estimators.
+const (
+	EstimatorWindow     = "window"
+	EstimatorMinQueries = "min_queries"
+	EstimatorWeighted   = "weighted"
+)
+
+// Usage selects how the queries of a service turn into watched time. Every
+// estimator counts Window for each watch interval; min_queries only counts
+// intervals with at least MinQueries queries, weighted only intervals whose
+// query weights add up to 1.
+type Usage struct {
+	Estimator     string
+	Window        time.Duration
+	MinQueries    int
+	Weights       map[string]float64 // domain pattern -> weight
+	DefaultWeight float64
+	WeightRules   []WeightRule // Weights parsed by Load
+}
+
+// WeightRule is the weight of queries for domains matching the pattern.
+type WeightRule struct {
+	Pattern domain.Pattern
+	Weight  float64
+}
+
+// Weight returns the weight of a query for the domain name. The highest
+// weight of all matching patterns wins.
+func (u Usage) Weight(name string) float64 {
+	weight, found := 0.0, false
+	for _, rule := range u.WeightRules {
+		if rule.Pattern.Match(name) && (!found || rule.Weight > weight) {
+			weight, found = rule.Weight, true
+		}
+	}
+	if !found {
+		return u.DefaultWeight
+	}
+	return weight
+}
+
+func newService(name string, domains []string) Service {
+	return Service{
+		Name:    name,
+		Domains: domains,
+		Usage: Usage{
+			Estimator:     EstimatorWindow,
+			Window:        5 * time.Minute,
+			MinQueries:    3,
+			DefaultWeight: 1,
+		},
+	}
+}
+
+var builtinServices = map[string][]string{
+	"youtube": {
+		"*youtube*",
+		"*googlevideo*",
+		"domain:ggpht.com",
+		"domain:youtu.be",
+		"domain:yt.be",
+		"domain:ytimg.com",
+		"*googleusercontent.com",
+	},
+}
+
+// Service returns the service with the given name.
+func (c Config) Service(name string) (Service, bool) {
+	for _, service := range c.Services {
+		if service.Name == name {
+			return service, true
+		}
+	}
+	return Service{}, false
+}
+
+// Match returns the first service pattern matching the domain name.
+func (s Service) Match(name string) (domain.Pattern, bool) {
+	for _, pattern := range s.Patterns {
+		if pattern.Match(name) {
+			return pattern, true
+		}
+	}
+	return domain.Pattern{}, false
+}
+
+// buildPatterns parses the domains and loads the domain list of every service.
+func (c *Config) buildPatterns() errorList {
+	var errs errorList
+	for i, service := range c.Services {
+		var patterns []domain.Pattern
+		for _, value := range service.Domains {
+			pattern, err := domain.Parse(value)
+			if err != nil {
+				errs.add("services."+service.Name+".domains", err)
+				continue
+			}
+			patterns = appendPattern(patterns, pattern)
+		}
+
+		if service.DomainList != "" {
+			rules, err := domainlist.Load(c.DomainListsDir, service.DomainList)
+			if err != nil {
+				errs.add("services."+service.Name+".domain_list", err)
+			}
+			for _, rule := range rules {
+				patterns = appendPattern(patterns, rule.Pattern)
+			}
+		}
+		c.Services[i].Patterns = patterns
+
+		rules := []WeightRule{}
+		for _, value := range sortedKeys(service.Usage.Weights) {
+			pattern, err := domain.Parse(value)
+			if err != nil {
+				errs.add("services."+service.Name+".usage.weights", err)
+				continue
+			}
+			rules = append(rules, WeightRule{Pattern: pattern, Weight: service.Usage.Weights[value]})
+		}
+		c.Services[i].Usage.WeightRules = rules
+
+		var required []domain.Pattern
+		for _, value := range service.Noise.RequireDomains {
+			pattern, err := domain.Parse(value)
+			if err != nil {
+				errs.add("services."+service.Name+".noise.require_domains", err)
+				continue
+			}
+			required = append(required, pattern)
+		}
+		c.Services[i].Noise.RequirePatterns = required
+		if service.Noise.Window == 0 {
+			c.Services[i].Noise.Window = service.Usage.Window
+		}
+	}
+	return errs
+}
+
+func appendPattern(patterns []domain.Pattern, pattern domain.Pattern) []domain.Pattern {
+	if slices.Contains(patterns, pattern) {
+		return patterns
+	}
+	return append(patterns, pattern)
+}
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ValidationError lists every problem found in the config.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d problem(s) found:", len(e.Errors))
+	for _, err := range e.Errors {
+		b.WriteString("\n  - ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
+// errorList collects config errors so all of them can be reported at once.
+type errorList []error
+
+func (l *errorList) add(key string, err error) {
+	*l = append(*l, fmt.Errorf("%s: %w", key, err))
+}
+
+// MessageData is available to notification message templates,
+// e.g. "Bedtime in {{.Minutes}} minutes."
+type MessageData struct {
+	Client    string
+	Profile   string
+	Service   string
+	Limit     time.Duration
+	Watched   time.Duration
+	Remaining time.Duration
+	Minutes   int
+}
+
+// validate checks the final config. Keys are named after the config file
+// with the matching environment variable in brackets.
+func (c Config) validate() errorList {
+	var errs errorList
+
+	if c.PiholeAddress == "" {
+		errs.add("pihole.address (PIHOLE_ADDRESS)", errors.New("is required"))
+	} else if err := validateURL(c.PiholeAddress); err != nil {
+		errs.add("pihole.address (PIHOLE_ADDRESS)", err)
+	}
+	if _, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(c.TOTPSecret); err != nil {
+		errs.add("pihole.totp_secret (PIHOLE_TOTP_SECRET)", errors.New("must be a base32 secret"))
+	}
+	if c.QueryPageSize <= 0 {
+		errs.add("pihole.page_size (PIHOLE_PAGE_SIZE)", errors.New("must be positive"))
+	}
+	if c.MaxQueryPages <= 0 {
+		errs.add("pihole.max_pages (PIHOLE_MAX_PAGES)", errors.New("must be positive"))
+	}
+	if c.CleanupAfter < 0 {
+		errs.add("pihole.cleanup_after_days (PIHOLE_CLEANUP_AFTER_DAYS)", errors.New("must not be negative"))
+	}
+	if c.Retries < 0 {
+		errs.add("pihole.retries (PIHOLE_RETRIES)", errors.New("must not be negative"))
+	}
+	if c.RetryBackoff <= 0 {
+		errs.add("pihole.retry_backoff (PIHOLE_RETRY_BACKOFF)", errors.New("must be positive"))
+	}
+	if c.CheckInternal <= 0 {
+		errs.add("check_interval (CHECK_INTERNAL)", errors.New("must be positive"))
+	}
+	if port, err := strconv.Atoi(c.ApiPort); err != nil || port < 1 || port > 65535 {
+		errs.add("api_port (API_PORT)", fmt.Errorf("invalid port %q", c.ApiPort))
+	}
+	switch c.StateBackend {
+	case "json", "sqlite", "none":
+	default:
+		errs.add("state.backend (STATE_BACKEND)", fmt.Errorf("unknown backend %q, expected json, sqlite or none", c.StateBackend))
+	}
+	if (c.TelegramToken == "") != (c.TelegramChatID == "") {
+		errs.add("notifiers.telegram (TELEGRAM_BOT_TOKEN, TELEGRAM_CHAT_ID)", errors.New("token and chat id must be set together"))
+	}
+	if c.SpeakerURL != "" {
+		if err := validateURL(c.SpeakerURL); err != nil {
+			errs.add("notifiers.speaker.url (SPEAKER_URL)", err)
+		}
+	}
+
+	if len(c.Services) == 0 {
+		errs.add("services (SERVICES)", errors.New("at least one service is required"))
+	}
+	seen := map[string]bool{}
+	for _, service := range c.Services {
+		if seen[service.Name] {
+			errs.add("services."+service.Name, errors.New("defined more than once"))
+		}
+		seen[service.Name] = true
+		service.Usage.validate("services."+service.Name+".usage", &errs)
+		service.Noise.validate("services."+service.Name+".noise", &errs)
+	}
+
+	for _, name := range sortedKeys(c.Profiles) {
+		errs = append(errs, c.Profiles[name].validate("profiles."+name)...)
+	}
+
+	if c.DefaultProfile != NoProfile {
+		if _, ok := c.Profiles[c.DefaultProfile]; !ok {
+			errs.add("default_profile (DEFAULT_PROFILE)", fmt.Errorf("unknown profile %q", c.DefaultProfile))
+		}
+	}
+	owners := map[string]string{}
+	for _, name := range sortedKeys(c.People) {
+		errs = append(errs, c.People[name].validate("people."+name, c, owners)...)
+	}
+	for _, client := range sortedKeys(c.ClientProfiles) {
+		key := "clients." + client + " (CLIENT_PROFILES)"
+		if err := ValidateClient(client); err != nil {
+			errs.add(key, err)
+		}
+		if profile := c.ClientProfiles[client]; profile != NoProfile {
+			if _, ok := c.Profiles[profile]; !ok {
+				errs.add(key, fmt.Errorf("unknown profile %q", profile))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (p Profile) validate(key string) errorList {
+	var errs errorList
+
+	p.Schedule.validate(key+".limit", &errs)
+	for _, service := range sortedKeys(p.ServiceSchedules) {
+		p.ServiceSchedules[service].validate(key+".services."+service, &errs)
+	}
+	if p.WarningThreshold < 0 {
+		errs.add(key+".warning_threshold", errors.New("must not be negative"))
+	}
+	if p.CurfewWarning < 0 {
+		errs.add(key+".curfew_warning", errors.New("must not be negative"))
+	}
+
+	messages := map[string]string{
+		"near_limit_message":     p.NearLimitMessage,
+		"limit_reached_message":  p.LimitReachedMessage,
+		"curfew_warning_message": p.CurfewWarningMessage,
+		"curfew_reached_message": p.CurfewReachedMessage,
+	}
+	for _, name := range sortedKeys(messages) {
+		if err := ValidateMessage(messages[name]); err != nil {
+			errs.add(key+"."+name, err)
+		}
+	}
+
+	validateWindows(p.AllowedWindows, key+".allowed_windows", &errs)
+	return errs
+}
+
+func (u Usage) validate(key string, errs *errorList) {
+	switch u.Estimator {
+	case EstimatorWindow, EstimatorMinQueries, EstimatorWeighted:
+	default:
+		errs.add(key+".estimator", fmt.Errorf("unknown estimator %q, expected window, min_queries or weighted", u.Estimator))
+	}
+	if u.Window <= 0 {
+		errs.add(key+".window", errors.New("must be positive"))
+	}
+	if u.MinQueries < 1 {
+		errs.add(key+".min_queries", errors.New("must be at least 1"))
+	}
+	if u.DefaultWeight < 0 {
+		errs.add(key+".default_weight", errors.New("must not be negative"))
+	}
+	for _, pattern := range sortedKeys(u.Weights) {
+		if u.Weights[pattern] < 0 {
+			errs.add(key+".weights."+pattern, errors.New("must not be negative"))
+		}
+	}
+}
+
+func (n Noise) validate(key string, errs *errorList) {
+	for _, t := range n.IgnoreTypes {
+		if !slices.Contains(queryTypes, t) {
+			errs.add(key+".ignore_types", fmt.Errorf("unknown query type %q, expected one of %s", t, strings.Join(queryTypes, ", ")))
+		}
+	}
+	for _, status := range n.IgnoreStatuses {
+		if !slices.Contains(queryStatuses, status) {
+			errs.add(key+".ignore_statuses", fmt.Errorf("unknown query status %q, expected one of %s", status, strings.Join(queryStatuses, ", ")))
+		}
+	}
+	if n.Window < 0 {
+		errs.add(key+".window", errors.New("must not be negative"))
+	}
+}
+
+func (s Schedule) validate(key string, errs *errorList) {
+	limits := map[string]time.Duration{"default": s.Default}
+	if s.Weekdays != nil {
+		limits["weekdays"] = *s.Weekdays
+	}
+	if s.Weekend != nil {
+		limits["weekend"] = *s.Weekend
+	}
+	for day, limit := range s.Days {
+		limits[strings.ToLower(day.String())] = limit
+	}
+	for _, rule := range sortedKeys(limits) {
+		if limits[rule] < 0 {
+			errs.add(key+"."+rule, errors.New("must not be negative"))
+		}
+	}
+}
+
+// validateWindows reports windows that overlap on the same day. Windows that
+// only touch, like 15:00-18:00 and 18:00-20:00, are fine.
+func validateWindows(windows []Window, key string, errs *errorList) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		var today []Window
+		for _, w := range windows {
+			if w.appliesTo(day) {
+				today = append(today, w)
+			}
+		}
+		sort.Slice(today, func(i, j int) bool { return today[i].Start < today[j].Start })
+		for i := 1; i < len(today); i++ {
+			if today[i].Start < today[i-1].End {
+				errs.add(key, fmt.Errorf("%s: %s overlaps %s", strings.ToLower(day.String()), formatWindow(today[i-1]), formatWindow(today[i])))
+			}
+		}
+	}
+}
+
+// ValidateMessage checks that a message template parses and only uses
+// fields of MessageData.
+func ValidateMessage(message string) error {
+	tmpl, err := template.New("message").Parse(message)
+	if err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	if err := tmpl.Execute(io.Discard, MessageData{}); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	return nil
+}
+
+// ValidateClient accepts a client key: an IP address, a CIDR subnet, a MAC
+// address or a host name.
+func ValidateClient(client string) error {
+	if net.ParseIP(client) != nil {
+		return nil
+	}
+	if _, err := netip.ParsePrefix(client); err == nil {
+		return nil
+	}
+	if _, err := net.ParseMAC(client); err == nil {
+		return nil
+	}
+	if isHostname(client) {
+		return nil
+	}
+	return fmt.Errorf("expected an IP address, CIDR subnet, MAC address or host name, got %q", client)
+}
+
+// isHostname reports whether name is a host name. Names without letters are
+// rejected, so a mistyped IP address is not taken for one.
+func isHostname(name string) bool {
+	letters := false
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z':
+			letters = true
+		case r >= '0' && r <= '9', r == '-', r == '.', r == '_':
+		default:
+			return false
+		}
+	}
+	return letters
+}
+
+func validateURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", value, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid URL %q: scheme must be http or https", value)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid URL %q: host is missing", value)
+	}
+	return nil
+}
+
+func formatWindow(w Window) string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", int(w.Start.Hours()), int(w.Start.Minutes())%60, int(w.End.Hours()), int(w.End.Minutes())%60)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,214 @@
+// Package domain describes the domains of a service in one place, so the
+// queries that are counted and the rules that block them always agree.
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+)
+
+// Pattern kinds. Glob is the format of the config file ("*.ytimg.com"), the
+// others come from v2fly domain lists.
+const (
+	Glob    = "glob"
+	Domain  = "domain"
+	Full    = "full"
+	Keyword = "keyword"
+	Regexp  = "regexp"
+)
+
+// Pattern matches a set of domain names.
+type Pattern struct {
+	Kind  string
+	Value string
+}
+
+// New returns a validated pattern of the given kind.
+func New(kind, value string) (Pattern, error) {
+	if kind != Regexp {
+		value = strings.ToLower(value)
+	}
+	if value == "" {
+		return Pattern{}, fmt.Errorf("empty %s pattern", kind)
+	}
+
+	switch kind {
+	case Glob:
+		if strings.Trim(value, "*") == "" {
+			return Pattern{}, fmt.Errorf("glob %q matches every domain", value)
+		}
+		if err := checkName(strings.ReplaceAll(value, "*", "")); err != nil {
+			return Pattern{}, fmt.Errorf("invalid glob %q: %w", value, err)
+		}
+	case Domain, Full, Keyword:
+		if err := checkName(value); err != nil {
+			return Pattern{}, fmt.Errorf("invalid %s %q: %w", kind, value, err)
+		}
+	case Regexp:
+		if _, err := regexp.Compile(value); err != nil {
+			return Pattern{}, fmt.Errorf("invalid regexp %q: %w", value, err)
+		}
+		if len(regexpLiterals(value)) == 0 {
+			return Pattern{}, fmt.Errorf("regexp %q has no literal text to search the query log for", value)
+		}
+		if _, err := posixRegex(value); err != nil {
+			return Pattern{}, fmt.Errorf("regexp %q: %w", value, err)
+		}
+	default:
+		return Pattern{}, fmt.Errorf("unknown pattern kind %q", kind)
+	}
+	return Pattern{Kind: kind, Value: value}, nil
+}
+
+// Parse parses a pattern of the config file. Globs are written as they are,
+// other kinds with their prefix, e.g. "full:www.youtube.com".
+func Parse(s string) (Pattern, error) {
+	if kind, value, ok := strings.Cut(s, ":"); ok {
+		return New(kind, value)
+	}
+	return New(Glob, s)
+}
+
+func (p Pattern) String() string {
+	if p.Kind == Glob {
+		return p.Value
+	}
+	return p.Kind + ":" + p.Value
+}
+
+func (p Pattern) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// QueryFilters returns the Pi-hole query log filters ("*" wildcards) that
+// find every query matching the pattern. They may find other queries as well,
+// so the results must be checked with Match.
+func (p Pattern) QueryFilters() []string {
+	switch p.Kind {
+	case Glob, Full:
+		return []string{p.Value}
+	case Domain:
+		return []string{"*" + p.Value}
+	case Keyword:
+		return []string{"*" + p.Value + "*"}
+	case Regexp:
+		var filters []string
+		for _, literal := range regexpLiterals(p.Value) {
+			filters = append(filters, "*"+literal+"*")
+		}
+		return filters
+	}
+	return nil
+}
+
+// Regex returns the pattern as a Pi-hole deny regex, in POSIX extended
+// syntax. It matches exactly the domains Match accepts.
+func (p Pattern) Regex() string {
+	switch p.Kind {
+	case Glob:
+		parts := strings.Split(p.Value, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		return "^" + strings.Join(parts, ".*") + "$"
+	case Domain:
+		return `(^|\.)` + regexp.QuoteMeta(p.Value) + `$`
+	case Full:
+		return "^" + regexp.QuoteMeta(p.Value) + "$"
+	case Keyword:
+		return regexp.QuoteMeta(p.Value)
+	}
+	// Checked by New
+	expr, _ := posixRegex(p.Value)
+	return expr
+}
+
+// Exact returns the only domain the pattern matches, if there is one. Such
+// patterns are blocked with an exact deny entry instead of a regex.
+func (p Pattern) Exact() (string, bool) {
+	if p.Kind == Full || (p.Kind == Glob && !strings.Contains(p.Value, "*")) {
+		return p.Value, true
+	}
+	return "", false
+}
+
+// Match reports whether the domain name matches the pattern.
+func (p Pattern) Match(name string) bool {
+	return compile(p.Regex()).MatchString(strings.ToLower(name))
+}
+
+var compiled sync.Map // regex -> *regexp.Regexp
+
+func compile(expr string) *regexp.Regexp {
+	if re, ok := compiled.Load(expr); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(expr)
+	compiled.Store(expr, re)
+	return re
+}
+
+func checkName(name string) error {
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '_') {
+			return fmt.Errorf("unexpected character %q", r)
+		}
+	}
+	if name == "" {
+		return errors.New("no domain name")
+	}
+	return nil
+}
+
+// regexpLiterals returns literals such that every domain matching the regexp
+// contains at least one of them. It returns nothing when no such literal is found.
+func regexpLiterals(expr string) []string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return literals(re.Simplify())
+}
+
+func literals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			return []string{strings.ToLower(string(re.Rune))}
+		}
+	case syntax.OpCapture, syntax.OpPlus:
+		return literals(re.Sub[0])
+	case syntax.OpConcat:
+		// Longer literals find fewer unrelated queries
+		var best []string
+		for _, sub := range re.Sub {
+			if found := literals(sub); found != nil && (best == nil || shortest(found) > shortest(best)) {
+				best = found
+			}
+		}
+		return best
+	case syntax.OpAlternate:
+		var all []string
+		for _, sub := range re.Sub {
+			found := literals(sub)
+			if found == nil {
+				return nil
+			}
+			all = append(all, found...)
+		}
+		return all
+	}
+	return nil
+}
+
+func shortest(literals []string) int {
+	n := len(literals[0])
+	for _, literal := range literals[1:] {
+		n = min(n, len(literal))
+	}
+	return n
+}
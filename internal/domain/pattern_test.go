@@ -0,0 +1,224 @@
+package domain
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		regex   string
+		filters []string
+		exact   string
+		match   []string
+		noMatch []string
+	}{
+		{
+			pattern: "*.ytimg.com",
+			regex:   `^.*\.ytimg\.com$`,
+			filters: []string{"*.ytimg.com"},
+			match:   []string{"i.ytimg.com", "a.b.ytimg.com"},
+			noMatch: []string{"ytimg.com", "xytimg.com", "i.ytimg.com.evil.net"},
+		},
+		{
+			pattern: "*youtube*",
+			regex:   `^.*youtube.*$`,
+			filters: []string{"*youtube*"},
+			match:   []string{"youtube.com", "www.youtube.com", "youtube-nocookie.com"},
+			noMatch: []string{"youtu.be", "ytimg.com"},
+		},
+		{
+			pattern: "YouTube.com",
+			regex:   `^youtube\.com$`,
+			filters: []string{"youtube.com"},
+			exact:   "youtube.com",
+			match:   []string{"youtube.com"},
+			noMatch: []string{"www.youtube.com", "youtubexcom", "youtube.com.evil.net"},
+		},
+		{
+			pattern: "domain:ytimg.com",
+			regex:   `(^|\.)ytimg\.com$`,
+			filters: []string{"*ytimg.com"},
+			match:   []string{"ytimg.com", "i.ytimg.com", "a.b.ytimg.com"},
+			noMatch: []string{"xytimg.com", "ytimg.com.evil.net", "ytimgxcom"},
+		},
+		{
+			pattern: "full:www.youtube.com",
+			regex:   `^www\.youtube\.com$`,
+			filters: []string{"www.youtube.com"},
+			exact:   "www.youtube.com",
+			match:   []string{"www.youtube.com"},
+			noMatch: []string{"youtube.com", "m.www.youtube.com", "wwwxyoutube.com"},
+		},
+		{
+			pattern: "keyword:googlevideo",
+			regex:   `googlevideo`,
+			filters: []string{"*googlevideo*"},
+			match:   []string{"googlevideo.com", "rr1---sn-abc.googlevideo.com", "xgooglevideox"},
+			noMatch: []string{"google.com", "video.google.com"},
+		},
+		{
+			pattern: `regexp:^yt[0-9]\.ggpht\.com$`,
+			regex:   `^yt[0-9]\.ggpht\.com$`,
+			filters: []string{"*.ggpht.com*"},
+			match:   []string{"yt3.ggpht.com"},
+			noMatch: []string{"ytx.ggpht.com", "yt3.ggpht.com.evil.net", "ggpht.com"},
+		},
+		{
+			// Alternatives without a common literal get a filter each
+			pattern: `regexp:^(music|gaming)\.youtube\.com$|^youtu\.be$`,
+			regex:   `^(music|gaming)\.youtube\.com$|^youtu\.be$`,
+			filters: []string{"*.youtube.com*", "*youtu.be*"},
+			match:   []string{"music.youtube.com", "gaming.youtube.com", "youtu.be"},
+			noMatch: []string{"www.youtube.com", "youtu.be.evil.net"},
+		},
+		{
+			pattern: `regexp:^(www|m)\.youtube\.com$`,
+			regex:   `^(www|m)\.youtube\.com$`,
+			filters: []string{"*.youtube.com*"},
+			match:   []string{"www.youtube.com", "m.youtube.com"},
+			noMatch: []string{"music.youtube.com", "youtube.com"},
+		},
+		{
+			// The common prefix of the alternatives finds both
+			pattern: `regexp:^(youtube|youtu\.be)$`,
+			regex:   `^(youtu(be|\.be))$`,
+			filters: []string{"*youtu*"},
+			match:   []string{"youtube", "youtu.be"},
+			noMatch: []string{"youtube.com", "youtuxbe"},
+		},
+		{
+			// Pi-hole speaks POSIX: Perl classes are spelled out
+			pattern: `regexp:^r\d+---sn-\w+\.googlevideo\.com$`,
+			regex:   `^r[0-9]+---sn-[0-9A-Za-z_]+\.googlevideo\.com$`,
+			filters: []string{"*.googlevideo.com*"},
+			match:   []string{"r1---sn-abc.googlevideo.com"},
+			noMatch: []string{"rx---sn-abc.googlevideo.com", "r1---sn-.googlevideo.com"},
+		},
+		{
+			// Non-capturing groups and lazy quantifiers become plain ones
+			pattern: `regexp:^(?:www\.)?yt\d+?\.youtube\.com$`,
+			regex:   `^(www\.)?yt[0-9]+\.youtube\.com$`,
+			filters: []string{"*.youtube.com*"},
+			match:   []string{"yt1.youtube.com", "www.yt12.youtube.com"},
+			noMatch: []string{"m.yt1.youtube.com", "yt.youtube.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			p, err := Parse(tt.pattern)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.pattern, err)
+			}
+			if got := p.Regex(); got != tt.regex {
+				t.Errorf("Regex() = %q, want %q", got, tt.regex)
+			}
+			if got := p.QueryFilters(); !slices.Equal(got, tt.filters) {
+				t.Errorf("QueryFilters() = %q, want %q", got, tt.filters)
+			}
+			exact, ok := p.Exact()
+			if exact != tt.exact || ok != (tt.exact != "") {
+				t.Errorf("Exact() = %q, %v, want %q", exact, ok, tt.exact)
+			}
+
+			// Pi-hole blocks with the regex (or the exact entry), the app
+			// counts with Match: both must agree on every name
+			block := regexp.MustCompile(p.Regex())
+			for _, name := range append(slices.Clone(tt.match), tt.noMatch...) {
+				want := slices.Contains(tt.match, name)
+				if got := p.Match(name); got != want {
+					t.Errorf("Match(%q) = %v, want %v", name, got, want)
+				}
+				if got := block.MatchString(name); got != want {
+					t.Errorf("regex %q matches %q = %v, want %v", p.Regex(), name, got, want)
+				}
+				if ok && (name == exact) != want {
+					t.Errorf("exact entry %q and Match disagree on %q", exact, name)
+				}
+				// Every counted query must be found by a query log filter
+				if want && !slices.ContainsFunc(tt.filters, func(filter string) bool { return filterMatches(filter, name) }) {
+					t.Errorf("no query filter of %q finds %q", tt.filters, name)
+				}
+			}
+		})
+	}
+}
+
+func TestUnsupportedRegexp(t *testing.T) {
+	for _, expr := range []string{
+		`\byoutube\.com$`,
+		`^youtube\.(com|)$`,
+		`^\s+youtube\.com$`,
+		`^youtube\.com\B`,
+	} {
+		if _, err := New(Regexp, expr); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("New(%q) = %v, want %v", expr, err, ErrUnsupported)
+		}
+	}
+}
+
+// filterMatches reports whether a Pi-hole query log filter, with "*" as
+// wildcard, finds the name.
+func filterMatches(filter, name string) bool {
+	parts := strings.Split(filter, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$").MatchString(name)
+}
+
+func TestMatchIgnoresCase(t *testing.T) {
+	p, err := Parse("domain:ytimg.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Match("I.YTIMG.com") {
+		t.Errorf("Match(%q) = false, want true", "I.YTIMG.com")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		kind  string
+		value string
+		want  string
+	}{
+		{kind: Glob, value: "*", want: "matches every domain"},
+		{kind: Glob, value: "**", want: "matches every domain"},
+		{kind: Glob, value: "", want: "empty glob pattern"},
+		{kind: Glob, value: "you tube.com", want: "unexpected character"},
+		{kind: Domain, value: "youtube.com/watch", want: "unexpected character"},
+		{kind: Full, value: "", want: "empty full pattern"},
+		{kind: Keyword, value: "you*tube", want: "unexpected character"},
+		{kind: Regexp, value: "(?i)youtube", want: "has no literal text"},
+		{kind: Regexp, value: "^.*$", want: "has no literal text"},
+		{kind: Regexp, value: "(youtube|.*)", want: "has no literal text"},
+		{kind: Regexp, value: "(", want: "invalid regexp"},
+		{kind: "include", value: "google", want: "unknown pattern kind"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind+":"+tt.value, func(t *testing.T) {
+			_, err := New(tt.kind, tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("New(%q, %q) error = %v, want it to contain %q", tt.kind, tt.value, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseString(t *testing.T) {
+	for _, s := range []string{"*.ytimg.com", "youtube.com", "domain:ytimg.com", "full:www.youtube.com", "keyword:googlevideo", `regexp:^yt[0-9]\.ggpht\.com$`} {
+		p, err := Parse(s)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", s, err)
+		}
+		if p.String() != s {
+			t.Errorf("Parse(%q).String() = %q", s, p.String())
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// ErrUnsupported is returned for regexps Pi-hole cannot evaluate.
+var ErrUnsupported = errors.New("not supported by Pi-hole")
+
+// posixRegex translates an RE2 regexp, the syntax of v2fly domain lists, to
+// the POSIX extended syntax of Pi-hole: "\d" becomes "[0-9]", "(?:" a group,
+// lazy quantifiers greedy ones, which match the same names. Character classes
+// are limited to the characters of domain names, so the result matches the
+// same names in both syntaxes.
+func posixRegex(expr string) (string, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := writePosix(&b, re); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func writePosix(b *strings.Builder, re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if err := writeRune(b, r, re.Flags&syntax.FoldCase != 0); err != nil {
+				return err
+			}
+		}
+	case syntax.OpCharClass:
+		return writeClass(b, re.Rune)
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		b.WriteByte('.')
+	case syntax.OpBeginLine, syntax.OpBeginText:
+		b.WriteByte('^')
+	case syntax.OpEndLine, syntax.OpEndText:
+		b.WriteByte('$')
+	case syntax.OpCapture:
+		b.WriteByte('(')
+		if err := writePosix(b, re.Sub[0]); err != nil {
+			return err
+		}
+		b.WriteByte(')')
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		if err := writeAtom(b, re.Sub[0]); err != nil {
+			return err
+		}
+		switch {
+		case re.Op == syntax.OpStar:
+			b.WriteByte('*')
+		case re.Op == syntax.OpPlus:
+			b.WriteByte('+')
+		case re.Op == syntax.OpQuest:
+			b.WriteByte('?')
+		case re.Max == re.Min:
+			fmt.Fprintf(b, "{%d}", re.Min)
+		case re.Max < 0:
+			fmt.Fprintf(b, "{%d,}", re.Min)
+		default:
+			fmt.Fprintf(b, "{%d,%d}", re.Min, re.Max)
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			write := writePosix
+			if sub.Op == syntax.OpAlternate {
+				write = writeGroup
+			}
+			if err := write(b, sub); err != nil {
+				return err
+			}
+		}
+	case syntax.OpAlternate:
+		for i, sub := range re.Sub {
+			if i > 0 {
+				b.WriteByte('|')
+			}
+			if err := writePosix(b, sub); err != nil {
+				return err
+			}
+		}
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return fmt.Errorf("word boundaries are %w", ErrUnsupported)
+	case syntax.OpEmptyMatch:
+		return fmt.Errorf("empty alternatives are %w", ErrUnsupported)
+	default:
+		return fmt.Errorf("%v is %w", re, ErrUnsupported)
+	}
+	return nil
+}
+
+// writeAtom writes re so a quantifier after it applies to all of it.
+func writeAtom(b *strings.Builder, re *syntax.Regexp) error {
+	switch {
+	case re.Op == syntax.OpLiteral && len(re.Rune) == 1,
+		re.Op == syntax.OpCharClass, re.Op == syntax.OpAnyChar, re.Op == syntax.OpAnyCharNotNL,
+		re.Op == syntax.OpCapture:
+		return writePosix(b, re)
+	}
+	return writeGroup(b, re)
+}
+
+func writeGroup(b *strings.Builder, re *syntax.Regexp) error {
+	b.WriteByte('(')
+	if err := writePosix(b, re); err != nil {
+		return err
+	}
+	b.WriteByte(')')
+	return nil
+}
+
+func writeRune(b *strings.Builder, r rune, foldCase bool) error {
+	switch {
+	case r > unicode.MaxASCII || !unicode.IsPrint(r):
+		return fmt.Errorf("character %q is %w", r, ErrUnsupported)
+	case foldCase && unicode.IsLetter(r):
+		fmt.Fprintf(b, "[%c%c]", unicode.ToLower(r), unicode.ToUpper(r))
+	case strings.ContainsRune(`.[]()*+?{}|^$\`, r):
+		b.WriteByte('\\')
+		b.WriteRune(r)
+	default:
+		b.WriteRune(r)
+	}
+	return nil
+}
+
+// writeClass writes the characters of domain names in the class, as ranges
+// of RE2 class ranges. Backslashes mean different things in the two syntaxes
+// inside brackets, so none are written.
+func writeClass(b *strings.Builder, ranges []rune) error {
+	in := func(r rune) bool {
+		for i := 0; i < len(ranges); i += 2 {
+			if ranges[i] <= r && r <= ranges[i+1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var class strings.Builder
+	for _, span := range [][2]rune{{'0', '9'}, {'A', 'Z'}, {'a', 'z'}} {
+		for r := span[0]; r <= span[1]; r++ {
+			if !in(r) {
+				continue
+			}
+			last := r
+			for last < span[1] && in(last+1) {
+				last++
+			}
+			switch {
+			case last == r:
+				class.WriteRune(r)
+			case last == r+1:
+				class.WriteRune(r)
+				class.WriteRune(last)
+			default:
+				fmt.Fprintf(&class, "%c-%c", r, last)
+			}
+			r = last
+		}
+	}
+	for _, r := range "._-" {
+		// "-" goes last, where it is no range
+		if in(r) {
+			class.WriteRune(r)
+		}
+	}
+	if class.Len() == 0 {
+		return fmt.Errorf("character class %w: it matches no character of domain names", ErrUnsupported)
+	}
+	if class.Len() == 1 && class.String() != "-" {
+		return writeRune(b, rune(class.String()[0]), false)
+	}
+	b.WriteByte('[')
+	b.WriteString(class.String())
+	b.WriteByte(']')
+	return nil
+}
@@ -0,0 +1,173 @@
+// Package domainlist reads domain lists in the format of
+// github.com/v2fly/domain-list-community.
+//
+// Every line of a list holds one rule, optionally followed by attributes:
+//
+//	# comment
+//	youtube.com            # same as domain:youtube.com
+//	domain:youtu.be @cn    # the domain and its subdomains
+//	full:www.youtube.com   # exactly this domain
+//	keyword:googlevideo    # any domain containing the keyword
+//	regexp:^yt[0-9]\.ggpht\.com$
+//	include:google @-ads   # rules of the list "google" without the attribute ads
+package domainlist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/vladikamira/pihole-parental-control/internal/domain"
+)
+
+// Rule is a single domain rule of a list.
+type Rule struct {
+	domain.Pattern
+	Attrs []string
+}
+
+// Load reads the list referenced by ref from dir and resolves its includes.
+// ref uses the syntax of include lines: "google", "google @ads" or "google @-ads".
+func Load(dir, ref string) ([]Rule, error) {
+	name, filter, err := parseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	l := &loader{dir: dir, lists: map[string][]Rule{}}
+	rules, err := l.load(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return filter.apply(rules), nil
+}
+
+type loader struct {
+	dir   string
+	lists map[string][]Rule
+}
+
+// load returns the rules of a list with its includes resolved. stack holds the
+// lists being loaded to detect include cycles.
+func (l *loader) load(name string, stack []string) ([]Rule, error) {
+	if rules, ok := l.lists[name]; ok {
+		return rules, nil
+	}
+	if slices.Contains(stack, name) {
+		return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), name)
+	}
+	stack = append(stack, name)
+
+	path := filepath.Join(l.dir, name)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open list %s: %w", name, err)
+	}
+	defer file.Close()
+
+	var rules []Rule
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		kind, value, attrs, ok := parseLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		if kind == "include" {
+			included, err := l.load(strings.ToLower(value), stack)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, attrFilter(attrs).apply(included)...)
+			continue
+		}
+
+		pattern, err := domain.New(kind, value)
+		if errors.Is(err, domain.ErrUnsupported) {
+			// Upstream lists are written for RE2, a few of their regexps
+			// cannot be blocked by Pi-hole
+			fmt.Printf("Skipping %s:%d: %v\n", path, line, err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		rules = append(rules, Rule{Pattern: pattern, Attrs: attrs})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read list %s: %w", name, err)
+	}
+
+	l.lists[name] = rules
+	return rules, nil
+}
+
+// parseLine splits a line into the rule kind, value and attributes. ok is
+// false for empty lines and comments.
+func parseLine(line string) (kind, value string, attrs []string, ok bool) {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", "", nil, false
+	}
+
+	kind, value, found := strings.Cut(fields[0], ":")
+	if !found {
+		kind, value = domain.Domain, fields[0]
+	}
+	for _, field := range fields[1:] {
+		// Affiliations (&list) only matter when building all lists at once
+		if strings.HasPrefix(field, "@") {
+			attrs = append(attrs, field[1:])
+		}
+	}
+	return kind, value, attrs, true
+}
+
+func parseRef(ref string) (string, attrFilter, error) {
+	fields := strings.Fields(ref)
+	if len(fields) == 0 {
+		return "", nil, errors.New("empty list name")
+	}
+	var filter attrFilter
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "@") || strings.TrimLeft(field, "@-") == "" {
+			return "", nil, fmt.Errorf("invalid attribute %q, expected @attr or @-attr", field)
+		}
+		filter = append(filter, field[1:])
+	}
+	return strings.ToLower(fields[0]), filter, nil
+}
+
+// attrFilter keeps rules having every "attr" and none of the "-attr" attributes.
+type attrFilter []string
+
+func (f attrFilter) apply(rules []Rule) []Rule {
+	if len(f) == 0 {
+		return rules
+	}
+	var filtered []Rule
+	for _, rule := range rules {
+		if f.matches(rule) {
+			filtered = append(filtered, rule)
+		}
+	}
+	return filtered
+}
+
+func (f attrFilter) matches(rule Rule) bool {
+	for _, attr := range f {
+		if name, ok := strings.CutPrefix(attr, "-"); ok {
+			if slices.Contains(rule.Attrs, name) {
+				return false
+			}
+		} else if !slices.Contains(rule.Attrs, attr) {
+			return false
+		}
+	}
+	return true
+}
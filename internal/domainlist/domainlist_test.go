@@ -0,0 +1,152 @@
+package domainlist
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeLists(t *testing.T, lists map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range lists {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// ruleStrings formats rules as "pattern @attr...", to compare them at a glance.
+func ruleStrings(rules []Rule) []string {
+	var out []string
+	for _, rule := range rules {
+		s := rule.Pattern.String()
+		for _, attr := range rule.Attrs {
+			s += " @" + attr
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeLists(t, map[string]string{
+		"youtube": `# YouTube
+YouTube.com
+domain:youtu.be @cn      # subdomains as well
+full:www.youtube.com @ads
+keyword:googlevideo
+regexp:^yt[0-9]\.ggpht\.com$
+regexp:\byoutube-ui\.com$      # skipped: Pi-hole has no word boundaries
+
+include:ytimg
+`,
+		"ytimg": "ytimg.com\nfull:ads.ytimg.com @ads &other\n",
+		"google": `google.com @ads
+include:YouTube @-ads
+`,
+	})
+
+	tests := []struct {
+		ref  string
+		want []string
+	}{
+		{
+			ref: "youtube",
+			want: []string{
+				"domain:youtube.com",
+				"domain:youtu.be @cn",
+				"full:www.youtube.com @ads",
+				"keyword:googlevideo",
+				`regexp:^yt[0-9]\.ggpht\.com$`,
+				"domain:ytimg.com",
+				"full:ads.ytimg.com @ads",
+			},
+		},
+		{
+			// Include filters apply to nested includes as well
+			ref: "google",
+			want: []string{
+				"domain:google.com @ads",
+				"domain:youtube.com",
+				"domain:youtu.be @cn",
+				"keyword:googlevideo",
+				`regexp:^yt[0-9]\.ggpht\.com$`,
+				"domain:ytimg.com",
+			},
+		},
+		{ref: "google @ads", want: []string{"domain:google.com @ads"}},
+		{ref: "youtube @ads @-cn", want: []string{"full:www.youtube.com @ads", "full:ads.ytimg.com @ads"}},
+		{ref: "YouTube @cn", want: []string{"domain:youtu.be @cn"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			rules, err := Load(dir, tt.ref)
+			if err != nil {
+				t.Fatalf("Load(%q) failed: %v", tt.ref, err)
+			}
+			if got := ruleStrings(rules); !slices.Equal(got, tt.want) {
+				t.Errorf("Load(%q) =\n%s\nwant\n%s", tt.ref, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := writeLists(t, map[string]string{
+		"a":       "a.com\ninclude:b\n",
+		"b":       "include:c\n",
+		"c":       "include:a\n",
+		"self":    "include:self\n",
+		"invalid": "good.com\nfull:bad!domain\n",
+		"regexp":  "regexp:(\n",
+		"missing": "include:nowhere\n",
+	})
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "a", want: "include cycle: a -> b -> c -> a"},
+		{ref: "self", want: "include cycle: self -> self"},
+		{ref: "invalid", want: filepath.Join(dir, "invalid") + `:2: invalid full "bad!domain"`},
+		{ref: "regexp", want: filepath.Join(dir, "regexp") + `:1: invalid regexp "("`},
+		{ref: "missing", want: "failed to open list nowhere"},
+		{ref: "", want: "empty list name"},
+		{ref: "a ads", want: `invalid attribute "ads"`},
+		{ref: "a @-", want: `invalid attribute "@-"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			_, err := Load(dir, tt.ref)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load(%q) error = %v, want it to contain %q", tt.ref, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		kind  string
+		value string
+		attrs []string
+		ok    bool
+	}{
+		{line: "", ok: false},
+		{line: "   # only a comment", ok: false},
+		{line: "youtube.com", kind: "domain", value: "youtube.com", ok: true},
+		{line: "full:www.youtube.com @ads @cn # comment", kind: "full", value: "www.youtube.com", attrs: []string{"ads", "cn"}, ok: true},
+		{line: "include:google @-ads &affiliation", kind: "include", value: "google", attrs: []string{"-ads"}, ok: true},
+	}
+	for _, tt := range tests {
+		kind, value, attrs, ok := parseLine(tt.line)
+		if kind != tt.kind || value != tt.value || !slices.Equal(attrs, tt.attrs) || ok != tt.ok {
+			t.Errorf("parseLine(%q) = %q, %q, %v, %v, want %q, %q, %v, %v", tt.line, kind, value, attrs, ok, tt.kind, tt.value, tt.attrs, tt.ok)
+		}
+	}
+}
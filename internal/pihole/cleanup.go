@@ -0,0 +1,171 @@
+package pihole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+)
+
+// Cleanup deletes what the service created in Pi-hole and does not need
+// anymore: the block groups of services that are gone, the clients it created
+// for Pi-hole clients not in keep and the deny rules no remaining block group
+// uses or no service blocks anymore. Groups, rules and clients created by
+// hand are left alone. Pi-hole drops the members and domains of deleted
+// groups itself. It returns the number of items deleted.
+func (c *Client) Cleanup(ctx context.Context, services []config.Service, keep []string) (int, error) {
+	return c.cleanup(ctx, services, services, keep)
+}
+
+// Uninstall deletes every group, deny rule and client the service created in
+// Pi-hole, which lifts all of its blocks. The services of the config find the
+// untagged groups of older versions.
+func (c *Client) Uninstall(ctx context.Context, services []config.Service) (int, error) {
+	return c.cleanup(ctx, services, nil, nil)
+}
+
+// cleanup deletes the items of the known services that the used ones do not
+// need.
+func (c *Client) cleanup(ctx context.Context, known, used []config.Service, keep []string) (int, error) {
+	if err := c.Auth(ctx); err != nil {
+		return 0, err
+	}
+
+	groups, err := c.listGroups(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list groups: %w", err)
+	}
+	clients, err := c.listClients(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list clients: %w", err)
+	}
+	rules, err := c.listDomains(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list domains: %w", err)
+	}
+
+	keepGroups := map[string]bool{}
+	for _, service := range used {
+		keepGroups[groupName(service.Name)] = true
+	}
+
+	deleted := 0
+	var errs []error
+	remove := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		deleted++
+	}
+
+	owned, kept := map[int]bool{}, map[int]bool{}
+	for _, group := range groups {
+		if !ownedGroup(group, known) {
+			continue
+		}
+		owned[group.ID] = true
+		if keepGroups[group.Name] {
+			kept[group.ID] = true
+			continue
+		}
+		fmt.Printf("Deleting group %s...\n", group.Name)
+		remove(c.delete(ctx, "/api/groups/"+url.PathEscape(group.Name)))
+	}
+
+	for _, client := range clients {
+		if slices.Contains(keep, client.IP) || !ownedClient(client, owned) {
+			continue
+		}
+		// Clients added to other groups by hand stay
+		if slices.ContainsFunc(client.Groups, func(gid int) bool { return gid != 0 && (!owned[gid] || kept[gid]) }) {
+			continue
+		}
+		fmt.Printf("Deleting client %s...\n", client.IP)
+		remove(c.delete(ctx, "/api/clients/"+url.PathEscape(client.IP)))
+	}
+
+	for _, rule := range rules {
+		if rule.Comment != ownerComment && !slices.Contains(legacyComments, rule.Comment) {
+			continue
+		}
+		inUse := slices.ContainsFunc(rule.Groups, func(gid int) bool { return kept[gid] })
+		if inUse && slices.ContainsFunc(used, func(service config.Service) bool { return serviceHasRule(service, rule) }) {
+			continue
+		}
+		fmt.Printf("Deleting %s domain %s...\n", rule.Kind, rule.Domain)
+		remove(c.delete(ctx, "/api/domains/deny/"+rule.Kind+"/"+url.PathEscape(rule.Domain)))
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// ownedGroup reports whether the service created the group: tagged groups,
+// the groups of the services, and the untagged groups of older versions,
+// which are recognized by their name.
+func ownedGroup(group Group, services []config.Service) bool {
+	if group.Comment == ownerComment || firstVersionGroup(group.Name) {
+		return true
+	}
+	for _, service := range services {
+		if group.Name == groupName(service.Name) {
+			return true
+		}
+		// A per-client group: "ParentalControl-youtube-192.168.1.15"
+		client, ok := strings.CutPrefix(group.Name, groupName(service.Name)+"-")
+		if ok && config.ValidateClient(client) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// firstVersionGroup reports whether the group is a block group of the first
+// versions, one per IP address ("ParentalControl-192.168.1.15"), which only
+// blocked YouTube.
+func firstVersionGroup(group string) bool {
+	ip, ok := strings.CutPrefix(group, "ParentalControl-")
+	return ok && net.ParseIP(ip) != nil
+}
+
+// ownedClient reports whether the service created the client. Clients of
+// older versions are untagged and recognized by being in block groups only.
+func ownedClient(client ClientItem, owned map[int]bool) bool {
+	if client.Comment == ownerComment {
+		return true
+	}
+	if client.Comment != "" {
+		return false
+	}
+	blockGroups := 0
+	for _, gid := range client.Groups {
+		switch {
+		case owned[gid]:
+			blockGroups++
+		case gid != 0:
+			return false
+		}
+	}
+	return blockGroups > 0
+}
+
+// delete deletes a Pi-hole item. Items that are gone already are ignored.
+func (c *Client) delete(ctx context.Context, path string) error {
+	req, _ := http.NewRequestWithContext(ctx, "DELETE", c.config.PiholeAddress+path, nil)
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 && resp.StatusCode != http.StatusNotFound {
+		return c.apiError("delete "+path, resp)
+	}
+	return nil
+}
@@ -4,15 +4,26 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"time"
 
 	"github.com/vladikamira/pihole-parental-control/internal/config"
+	"github.com/vladikamira/pihole-parental-control/internal/domain"
 )
 
+// ownerComment tags the groups, deny rules and clients the service creates in
+// Pi-hole, so Cleanup and Uninstall find them. Older versions used the other
+// comments, or none at all for groups.
+const ownerComment = "Managed by pihole-parental-control"
+
+var legacyComments = []string{"Parental Control", "Created by Parental Control"}
+
 func NewClient(config config.Config) *Client {
 	return &Client{
 		config: config,
@@ -26,74 +37,207 @@ type Client struct {
 	config            config.Config
 	client            *http.Client
 	sessionID         string
+	sessionValidity   time.Duration
 	sessionExpiration time.Time
+	lastTOTP          time.Time // time step of the last TOTP code used
+	breaker           breaker
 }
 
+// SetConfig swaps the config on reload. New credentials drop the session.
+func (c *Client) SetConfig(cfg config.Config) {
+	if cfg.PiholeAddress != c.config.PiholeAddress || cfg.Password != c.config.Password || cfg.TOTPSecret != c.config.TOTPSecret {
+		c.sessionID = ""
+	}
+	c.config = cfg
+}
+
+// Auth logs in unless the session is still valid. Pi-hole extends a session
+// whenever it is used, so does the client. Accounts with two-factor
+// authentication need the TOTP secret, application passwords log in without.
 func (c *Client) Auth(ctx context.Context) error {
 	if c.sessionID != "" && time.Now().Before(c.sessionExpiration) {
 		return nil
 	}
 
-	data, err := json.Marshal(map[string]string{"password": c.config.Password})
+	login := map[string]interface{}{"password": c.config.Password}
+	if c.config.TOTPSecret != "" {
+		code, err := c.nextTOTP(ctx)
+		if err != nil {
+			return err
+		}
+		login["totp"] = code
+	}
+	data, err := json.Marshal(login)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", c.config.PiholeAddress+"/api/auth", bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.config.PiholeAddress+"/api/auth", bytes.NewBuffer(data))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.client.Do(req)
+	resp, err := c.send(req)
 	if err != nil {
 		return err
 	}
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("request failed: %s", resp.Status)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
 	}
-
+	// Failed logins tell about two-factor authentication as well
 	var authResponse AuthResponse
-	if err := json.NewDecoder(resp.Body).Decode(&authResponse); err != nil {
-		return err
+	decodeErr := json.Unmarshal(body, &authResponse)
+	if !authResponse.Session.Valid && authResponse.Session.Totp && c.config.TOTPSecret == "" {
+		return fmt.Errorf("%w: two-factor authentication is enabled, set PIHOLE_TOTP_SECRET or use an application password", ErrAuth)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{Op: "auth", Status: resp.StatusCode, Body: string(body)}
+	}
+	if decodeErr != nil {
+		return decodeErr
 	}
 
 	if !authResponse.Session.Valid {
-		return fmt.Errorf("session is not valid: %s", authResponse.Session.Message)
+		return fmt.Errorf("%w: session is not valid: %s", ErrAuth, authResponse.Session.Message)
 	}
 
 	c.sessionID = authResponse.Session.Sid
-	c.sessionExpiration = time.Now().Add(time.Duration(authResponse.Session.Validity) * time.Second)
+	c.sessionValidity = time.Duration(authResponse.Session.Validity) * time.Second
+	c.sessionExpiration = time.Now().Add(c.sessionValidity)
 
 	fmt.Println("Auth successeded. Got session id: ", c.sessionID)
 
 	return nil
 }
 
-func (c *Client) GetDomainStats(ctx context.Context, domain string) (*QueryStats, error) {
+// Logout ends the session, so it does not take up one of the few session
+// slots of Pi-hole until it expires.
+func (c *Client) Logout(ctx context.Context) error {
+	if c.sessionID == "" {
+		return nil
+	}
+	req, _ := http.NewRequestWithContext(ctx, "DELETE", c.config.PiholeAddress+"/api/auth", nil)
+	req.Header.Set("X-FTL-SID", c.sessionID)
+	c.sessionID = ""
+
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// 401: the session was gone already
+	if resp.StatusCode >= 400 && resp.StatusCode != http.StatusUnauthorized {
+		return c.apiError("logout", resp)
+	}
+	return nil
+}
+
+// do sends a request with the session. When Pi-hole rejects the session, e.g.
+// because it restarted and forgot it, do logs in again and retries once.
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-FTL-SID", c.sessionID)
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	// 403 is a valid session without the permission, logging in again
+	// would only take another session slot
+	if resp.StatusCode != http.StatusUnauthorized {
+		c.sessionExpiration = time.Now().Add(c.sessionValidity)
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	fmt.Println("Pi-hole rejected the session. Logging in again...")
+	c.sessionID = ""
 	if err := c.Auth(ctx); err != nil {
 		return nil, err
 	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("X-FTL-SID", c.sessionID)
+	resp, err = c.send(retry)
+	if err == nil && resp.StatusCode < 400 {
+		c.sessionExpiration = time.Now().Add(c.sessionValidity)
+	}
+	return resp, err
+}
+
+// GetDomainStats returns the queries for the domain filter between from and
+// until. The query log is read page by page. When more than MaxQueryPages
+// pages match, only the oldest queries are read and Truncated is set, so the
+// caller continues after them on the next call instead of skipping them.
+func (c *Client) GetDomainStats(ctx context.Context, domain string, from, until time.Time) (*QueryStats, error) {
+	if err := c.Auth(ctx); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("domain", domain)
+	params.Set("from", strconv.FormatInt(from.Unix(), 10))
+	params.Set("until", strconv.FormatInt(until.Unix(), 10))
+	params.Set("length", strconv.Itoa(c.config.QueryPageSize))
+
+	var stats QueryStats
+	start := 0
+	for {
+		// The cursor of the first page keeps later pages stable while new
+		// queries arrive
+		params.Set("start", strconv.Itoa(start))
+		if stats.Pages > 0 {
+			params.Set("cursor", strconv.Itoa(stats.Cursor))
+		}
+
+		page, err := c.getQueries(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		if stats.Pages == 0 {
+			stats.Cursor = page.Cursor
+		}
+		stats.Pages++
+		stats.RecordsTotal = page.RecordsTotal
+		stats.RecordsFiltered = page.RecordsFiltered
+
+		if stats.Pages == 1 && page.RecordsFiltered > c.config.QueryPageSize*c.config.MaxQueryPages {
+			// Pi-hole returns the newest queries first, skip to the oldest
+			window := c.config.QueryPageSize * max(c.config.MaxQueryPages-1, 1)
+			fmt.Printf("Query log for %s has %d queries, reading the oldest %d\n", domain, page.RecordsFiltered, window)
+			stats.Truncated = true
+			start = page.RecordsFiltered - window
+			continue
+		}
+		stats.Queries = append(stats.Queries, page.Queries...)
+		start += len(page.Queries)
 
-	from := strconv.FormatInt(time.Now().Add(-c.config.CheckInternal).Unix(), 10)
-	until := strconv.FormatInt(time.Now().Unix(), 10)
+		if len(page.Queries) < c.config.QueryPageSize || start >= page.RecordsFiltered {
+			return &stats, nil
+		}
+	}
+}
 
-	req, err := http.NewRequest("GET", c.config.PiholeAddress+"/api/queries?domain="+domain+"&from="+from+"&until="+until, nil)
+func (c *Client) getQueries(ctx context.Context, params url.Values) (*QueryStats, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.config.PiholeAddress+"/api/queries?"+params.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("X-FTL-SID", c.sessionID)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, err
+		return nil, c.apiError("get queries", resp)
 	}
 	var stats QueryStats
 	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
@@ -102,137 +246,355 @@ func (c *Client) GetDomainStats(ctx context.Context, domain string) (*QueryStats
 	return &stats, nil
 }
 
-func (c *Client) BlockDomainsForClient(ctx context.Context, clientIP string, domains []string) error {
+// Devices returns the Pi-hole network table, the devices Pi-hole has seen
+// with their MAC address and recent IP addresses.
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
 	if err := c.Auth(ctx); err != nil {
-		return err
+		return nil, err
 	}
 
-	groupName := fmt.Sprintf("ParentalControl-%s", clientIP)
-	groupID, err := c.getOrCreateGroup(ctx, groupName)
+	// Pi-hole returns only 10 devices by default
+	params := url.Values{}
+	params.Set("max_devices", "1000")
+	params.Set("max_addresses", "25")
+	req, _ := http.NewRequestWithContext(ctx, "GET", c.config.PiholeAddress+"/api/network/devices?"+params.Encode(), nil)
+
+	resp, err := c.do(ctx, req)
 	if err != nil {
-		return fmt.Errorf("failed to get/create group: %w", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.apiError("get devices", resp)
 	}
 
-	fmt.Printf("Group %s created with id: %d\n", groupName, groupID)
-
-	// Add domains to group
-	for _, domain := range domains {
-		fmt.Printf("Adding domain %s to group: %d\n", domain, groupID)
-		if err := c.addDomainToGroup(ctx, domain, groupID); err != nil {
-			return fmt.Errorf("failed to add domain %s: %w", domain, err)
-		}
+	var devices DeviceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		return nil, err
 	}
+	return devices.Devices, nil
+}
 
-	// Add client to group
-	if err := c.addClientToGroup(ctx, clientIP, groupID); err != nil {
-		return fmt.Errorf("failed to add client to group: %w", err)
+// BlockDomainsForClient blocks the domains for a Pi-hole client, which is an
+// IP address, a MAC address or a host name. Every service has one block group
+// holding its deny rules, blocking adds the client to it. Only what is missing
+// is changed, so blocking a blocked client does nothing. When a step fails,
+// the changes of the previous steps are rolled back.
+func (c *Client) BlockDomainsForClient(ctx context.Context, client, service string, patterns []domain.Pattern) error {
+	if err := c.Auth(ctx); err != nil {
+		return err
 	}
 
+	var undo rollback
+	groupID, err := c.serviceGroup(ctx, service, patterns, &undo)
+	if err == nil {
+		if err = c.addClientToGroup(ctx, client, groupID); err != nil {
+			err = fmt.Errorf("failed to add client to group: %w", err)
+		}
+	}
+	if err != nil {
+		undo.run(ctx)
+		return err
+	}
 	return nil
 }
 
-func (c *Client) UnblockDomainsForClient(ctx context.Context, clientIP string) error {
+// UnblockDomainsForClient removes the client from the block group of the
+// service. The group keeps its deny rules for the next block. Unblocking a
+// client that is not blocked does nothing.
+func (c *Client) UnblockDomainsForClient(ctx context.Context, client, service string) error {
 	if err := c.Auth(ctx); err != nil {
 		return err
 	}
 
-	groupName := fmt.Sprintf("ParentalControl-%s", clientIP)
-	groupID, err := c.getGroupID(ctx, groupName)
+	groupID, err := c.getGroupID(ctx, groupName(service))
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get group id: %w", err)
 	}
 
 	// Remove client from group
-	if err := c.removeClientFromGroup(ctx, clientIP, groupID); err != nil {
+	if err := c.removeClientFromGroup(ctx, client, groupID); err != nil {
 		return fmt.Errorf("failed to remove client from group: %w", err)
 	}
 
 	return nil
 }
 
+// AddServiceDomains adds domains to the block group of the service, e.g. after
+// they were added to the config. Blocked clients are blocked for them at once.
+func (c *Client) AddServiceDomains(ctx context.Context, service string, patterns []domain.Pattern) error {
+	if err := c.Auth(ctx); err != nil {
+		return err
+	}
+	var undo rollback
+	if _, err := c.serviceGroup(ctx, service, patterns, &undo); err != nil {
+		undo.run(ctx)
+		return err
+	}
+	return nil
+}
+
+// rollback holds the steps that undo the changes made so far, so a failed
+// operation leaves Pi-hole as it was.
+type rollback []func(ctx context.Context) error
+
+// add records how to undo a change. A nil rollback records nothing.
+func (r *rollback) add(undo func(ctx context.Context) error) {
+	if r != nil {
+		*r = append(*r, undo)
+	}
+}
+
+// rollbackTimeout bounds undoing the changes of a failed operation.
+const rollbackTimeout = 10 * time.Second
+
+// run undoes the changes, newest first. The operation often failed because
+// its context ended, so the rollback gets a context of its own.
+func (r rollback) run(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	for i := len(r) - 1; i >= 0; i-- {
+		if err := r[i](ctx); err != nil {
+			fmt.Printf("Failed to roll back a change: %v\n", err)
+		}
+	}
+}
+
+// RemoveDomain deletes a deny rule created for blocking, e.g. after it was
+// removed from the config. Missing domains are ignored.
+func (c *Client) RemoveDomain(ctx context.Context, pattern domain.Pattern) error {
+	if err := c.Auth(ctx); err != nil {
+		return err
+	}
+
+	kind, value := denyRule(pattern)
+	return c.delete(ctx, "/api/domains/deny/"+kind+"/"+url.PathEscape(value))
+}
+
+// BlockedClients returns the clients (as Pi-hole knows them: IP address, MAC
+// address or host name) that are members of a block group, per service.
+func (c *Client) BlockedClients(ctx context.Context, services []string) (map[string][]string, error) {
+	if err := c.Auth(ctx); err != nil {
+		return nil, err
+	}
+
+	groups, err := c.listGroups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	clients, err := c.listClients(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clients: %w", err)
+	}
+
+	names := map[int]string{}
+	for _, g := range groups {
+		names[g.ID] = g.Name
+	}
+
+	blocked := map[string][]string{}
+	for _, client := range clients {
+		for _, gid := range client.Groups {
+			for _, service := range services {
+				if names[gid] == groupName(service) {
+					blocked[service] = append(blocked[service], client.IP)
+				}
+			}
+		}
+	}
+	return blocked, nil
+}
+
 // Helpers
 
-func (c *Client) getOrCreateGroup(ctx context.Context, name string) (int, error) {
+// groupName returns the block group of a service: "ParentalControl-youtube".
+func groupName(service string) string {
+	return "ParentalControl-" + service
+}
+
+// serviceGroup returns the block group of the service, creating it if needed,
+// and makes sure it holds the deny rules of the patterns. Rules are created
+// once and shared by every blocked client. Changes are recorded in undo.
+func (c *Client) serviceGroup(ctx context.Context, service string, patterns []domain.Pattern, undo *rollback) (int, error) {
+	name := groupName(service)
+	groupID, created, err := c.getOrCreateGroup(ctx, name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get/create group: %w", err)
+	}
+	if created {
+		undo.add(func(ctx context.Context) error {
+			return c.delete(ctx, "/api/groups/"+url.PathEscape(name))
+		})
+	}
+
+	rules, err := c.listDomains(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list domains: %w", err)
+	}
+	for _, pattern := range patterns {
+		rule := findRule(rules, pattern)
+		if ruleInGroup(rule, groupID) {
+			continue
+		}
+		fmt.Printf("Adding domain %s to group: %d\n", pattern, groupID)
+		if err := c.assignDomain(ctx, rule, pattern, groupID, undo); err != nil {
+			return 0, fmt.Errorf("failed to add domain %s: %w", pattern, err)
+		}
+	}
+	return groupID, nil
+}
+
+// findRule returns the deny rule of the pattern, or nil if there is none.
+func findRule(rules []DomainItem, pattern domain.Pattern) *DomainItem {
+	kind, value := denyRule(pattern)
+	for i, rule := range rules {
+		if rule.Kind == kind && rule.Domain == value {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// ruleInGroup reports whether the rule exists, is enabled and applies to the group.
+func ruleInGroup(rule *DomainItem, groupID int) bool {
+	return rule != nil && rule.Enabled && slices.Contains(rule.Groups, groupID)
+}
+
+// assignDomain adds the deny rule of the pattern to the group. A missing rule
+// is created, a disabled one enabled. The change is recorded in undo.
+func (c *Client) assignDomain(ctx context.Context, rule *DomainItem, pattern domain.Pattern, groupID int, undo *rollback) error {
+	if rule == nil {
+		if err := c.addDomainToGroup(ctx, pattern, groupID); err != nil {
+			return err
+		}
+		undo.add(func(ctx context.Context) error { return c.RemoveDomain(ctx, pattern) })
+		return nil
+	}
+	previous := *rule
+	update := *rule
+	if !slices.Contains(rule.Groups, groupID) {
+		update.Groups = append(slices.Clone(rule.Groups), groupID)
+	}
+	update.Enabled = true
+	if err := c.updateDomain(ctx, update); err != nil {
+		return err
+	}
+	*rule = update
+	undo.add(func(ctx context.Context) error { return c.updateDomain(ctx, previous) })
+	return nil
+}
+
+// denyRule returns the Pi-hole deny list kind (exact or regex) and the entry
+// for a pattern.
+func denyRule(pattern domain.Pattern) (kind, value string) {
+	if exact, ok := pattern.Exact(); ok {
+		return "exact", exact
+	}
+	return "regex", pattern.Regex()
+}
+
+// getOrCreateGroup returns the ID of the group and whether it was created.
+func (c *Client) getOrCreateGroup(ctx context.Context, name string) (int, bool, error) {
 	id, err := c.getGroupID(ctx, name)
 	if err == nil {
-		return id, nil
+		return id, false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return 0, false, err
 	}
 
 	// Create group
 	data, _ := json.Marshal(map[string]string{
-		"name":        name,
-		"description": "Created by Parental Control",
+		"name":    name,
+		"comment": ownerComment,
 	})
-	req, _ := http.NewRequest("POST", c.config.PiholeAddress+"/api/groups", bytes.NewBuffer(data))
-	req.Header.Set("X-FTL-SID", c.sessionID)
+	req, _ := http.NewRequestWithContext(ctx, "POST", c.config.PiholeAddress+"/api/groups", bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json") // Ensure Content-Type is set
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(ctx, req)
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return 0, fmt.Errorf("failed to create group, status: %d", resp.StatusCode)
+		err := c.apiError("create group", resp)
+		if errors.Is(err, ErrConflict) {
+			// Created at the same time by someone else
+			id, err := c.getGroupID(ctx, name)
+			return id, false, err
+		}
+		return 0, false, err
 	}
 
-	// Assume response contains ID? Or we fetch again.
-	// Pi-hole v6 API usually returns the object.
+	// Pi-hole v6 returns the created group
 	var res GroupResponse
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || len(res.Groups) == 0 {
 		// Fallback: fetch by name
-		return c.getGroupID(ctx, name)
+		id, err := c.getGroupID(ctx, name)
+		return id, err == nil, err
 	}
 	fmt.Println("Group created with id: ", res.Groups[0].ID)
-	return res.Groups[0].ID, nil
+	return res.Groups[0].ID, true, nil
 }
 
 func (c *Client) getGroupID(ctx context.Context, name string) (int, error) {
-	req, _ := http.NewRequest("GET", c.config.PiholeAddress+"/api/groups", nil)
-	req.Header.Set("X-FTL-SID", c.sessionID)
-	resp, err := c.client.Do(req)
+	groups, err := c.listGroups(ctx)
 	if err != nil {
 		return 0, err
 	}
+
+	for _, g := range groups {
+		if g.Name == name {
+			return g.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("group %s: %w", name, ErrNotFound)
+}
+
+func (c *Client) listGroups(ctx context.Context) ([]Group, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", c.config.PiholeAddress+"/api/groups", nil)
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.apiError("list groups", resp)
+	}
 
 	var groups GroupListResponse
 	// Note: Response structure might differ, simplified assumption
 	if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
-		return 0, err
-	}
-
-	for _, g := range groups.Groups {
-		if g.Name == name {
-			return g.ID, nil
-		}
+		return nil, err
 	}
-	return 0, fmt.Errorf("group not found")
+	return groups.Groups, nil
 }
 
-func (c *Client) addDomainToGroup(ctx context.Context, domain string, groupID int) error {
+func (c *Client) addDomainToGroup(ctx context.Context, pattern domain.Pattern, groupID int) error {
 	// Add domain (blacklist regex or exact)
+	kind, value := denyRule(pattern)
 	payload := map[string]interface{}{
-		"domain":  domain,
-		"comment": "Parental Control",
+		"domain":  value,
+		"comment": ownerComment,
 		"groups":  []int{groupID},
 		"enabled": true,
 	}
 	data, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", c.config.PiholeAddress+"/api/domains/deny/regex", bytes.NewBuffer(data))
-	req.Header.Set("X-FTL-SID", c.sessionID)
+	req, _ := http.NewRequestWithContext(ctx, "POST", c.config.PiholeAddress+"/api/domains/deny/"+kind, bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("add domain failed: %d, body: %s", resp.StatusCode, string(body))
+		return c.apiError("add domain", resp)
 	}
 
 	return nil
@@ -242,12 +604,12 @@ func (c *Client) addClientToGroup(ctx context.Context, ip string, groupID int) e
 	// First get client to preserve existing groups
 	client, err := c.getClient(ctx, ip)
 	exists := true
-	if err != nil {
-		// Client might not exist in database, create query
-		// But in Pi-hole clients are identified by IP.
-		// If we POST a new client, we specify groups.
-		client = &ClientItem{IP: ip, Groups: []int{}}
+	if errors.Is(err, ErrNotFound) {
+		// New clients stay in the Default group, which holds the adlists
+		client = &ClientItem{IP: ip, Comment: ownerComment, Groups: []int{0}}
 		exists = false
+	} else if err != nil {
+		return fmt.Errorf("failed to get client: %w", err)
 	}
 
 	// Check if already in group
@@ -265,8 +627,13 @@ func (c *Client) addClientToGroup(ctx context.Context, ip string, groupID int) e
 	return c.updateClient(ctx, client)
 }
 
+// removeClientFromGroup removes the client from the group. A client that is
+// not in the group, or not known to Pi-hole at all, is left as it is.
 func (c *Client) removeClientFromGroup(ctx context.Context, ip string, groupID int) error {
 	client, err := c.getClient(ctx, ip)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get client: %w", err)
 	}
@@ -282,23 +649,44 @@ func (c *Client) removeClientFromGroup(ctx context.Context, ip string, groupID i
 	}
 
 	if !found {
-		return fmt.Errorf("client %s not found in group %d", ip, groupID)
+		return nil
+	}
+	if len(newGroups) == 0 {
+		// A client in no group is not filtered at all, older versions
+		// created clients without the Default group
+		newGroups = []int{0}
 	}
 	client.Groups = newGroups
 	return c.updateClient(ctx, client)
 }
 
 func (c *Client) getClient(ctx context.Context, ip string) (*ClientItem, error) {
+	clients, err := c.listClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cl := range clients {
+		if cl.IP == ip {
+			return &cl, nil
+		}
+	}
+	return nil, fmt.Errorf("client %s: %w", ip, ErrNotFound)
+}
+
+func (c *Client) listClients(ctx context.Context) ([]ClientItem, error) {
 	// Need to list clients or get specific?
 	// Assuming GET /api/clients/{ip} or search
-	req, _ := http.NewRequest("GET", c.config.PiholeAddress+"/api/clients", nil) // List all?
-	req.Header.Set("X-FTL-SID", c.sessionID)
-	resp, err := c.client.Do(req)
+	req, _ := http.NewRequestWithContext(ctx, "GET", c.config.PiholeAddress+"/api/clients", nil) // List all?
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		fmt.Printf("getClient network error: %v\n", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.apiError("list clients", resp)
+	}
 
 	bodyBytes, _ := io.ReadAll(resp.Body)
 	// fmt.Printf("DEBUG: getClient response: %s\n", string(bodyBytes))
@@ -318,49 +706,38 @@ func (c *Client) getClient(ctx context.Context, ip string) (*ClientItem, error)
 			return nil, err
 		}
 	}
-
-	for _, cl := range response.Clients {
-		if cl.IP == ip {
-			return &cl, nil
-		}
-	}
-	fmt.Printf("getClient: IP %s not found in %d clients\n", ip, len(response.Clients))
-	return nil, fmt.Errorf("client not found")
+	return response.Clients, nil
 }
 
 func (c *Client) createClient(ctx context.Context, client *ClientItem) error {
 	data, _ := json.Marshal(client)
-	req, _ := http.NewRequest("POST", c.config.PiholeAddress+"/api/clients", bytes.NewBuffer(data))
-	req.Header.Set("X-FTL-SID", c.sessionID)
+	req, _ := http.NewRequestWithContext(ctx, "POST", c.config.PiholeAddress+"/api/clients", bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("create client failed: %d, body: %s", resp.StatusCode, string(body))
+		return c.apiError("create client", resp)
 	}
 	return nil
 }
 
 func (c *Client) updateClient(ctx context.Context, client *ClientItem) error {
 	data, _ := json.Marshal(client)
-	// Use PUT and append IP to URL for update
-	req, _ := http.NewRequest("PUT", c.config.PiholeAddress+"/api/clients/"+client.IP, bytes.NewBuffer(data))
-	req.Header.Set("X-FTL-SID", c.sessionID)
+	// Use PUT and append the client (IP or MAC address) to URL for update
+	req, _ := http.NewRequestWithContext(ctx, "PUT", c.config.PiholeAddress+"/api/clients/"+url.PathEscape(client.IP), bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("update client failed: %d, body: %s", resp.StatusCode, string(body))
+		return c.apiError("update client", resp)
 	}
 	return nil
 }
@@ -0,0 +1,137 @@
+package pihole
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+)
+
+const groupBody = `{"comment":"","enabled":true,"name":"ParentalControl-youtube"}`
+
+// fakePihole answers every login with a new session. It answers the first
+// rejects other requests with status, 401 if not set.
+type fakePihole struct {
+	logins   int
+	rejects  int
+	status   int
+	requests []string // "SID BODY" of every other request
+}
+
+func (f *fakePihole) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/auth" {
+		f.logins++
+		fmt.Fprintf(w, `{"session":{"valid":true,"sid":"sid%d","validity":300}}`, f.logins)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r.Header.Get("X-FTL-SID")+" "+string(body))
+	if f.rejects > 0 {
+		f.rejects--
+		w.WriteHeader(cmp.Or(f.status, http.StatusUnauthorized))
+		return
+	}
+	fmt.Fprint(w, `{}`)
+}
+
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewClient(config.Config{PiholeAddress: server.URL, Password: "pw", RetryBackoff: 1})
+}
+
+func TestDoLogsInAgainOnRejectedSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		rejects  int
+		status   int
+		wantErr  error
+		requests []string
+		logins   int
+	}{
+		{
+			name:     "accepted",
+			requests: []string{"sid1 " + groupBody},
+			logins:   1,
+		},
+		{
+			name:     "rejected once",
+			rejects:  1,
+			requests: []string{"sid1 " + groupBody, "sid2 " + groupBody},
+			logins:   2,
+		},
+		{
+			// Only one retry, a second rejection is an error
+			name:     "rejected twice",
+			rejects:  2,
+			wantErr:  ErrAuth,
+			requests: []string{"sid1 " + groupBody, "sid2 " + groupBody},
+			logins:   2,
+		},
+		{
+			// The session is valid, another one would not be allowed either
+			name:     "forbidden",
+			rejects:  1,
+			status:   http.StatusForbidden,
+			wantErr:  ErrForbidden,
+			requests: []string{"sid1 " + groupBody},
+			logins:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakePihole{rejects: tt.rejects, status: tt.status}
+			c := newTestClient(t, fake)
+			if err := c.Auth(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			err := c.updateGroup(context.Background(), Group{Name: "ParentalControl-youtube", Enabled: true})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("updateGroup error = %v, want %v", err, tt.wantErr)
+			}
+			if len(fake.requests) != len(tt.requests) {
+				t.Fatalf("got requests %q, want %q", fake.requests, tt.requests)
+			}
+			for i, want := range tt.requests {
+				if fake.requests[i] != want {
+					t.Errorf("request %d = %q, want %q", i, fake.requests[i], want)
+				}
+			}
+			if fake.logins != tt.logins {
+				t.Errorf("logged in %d times, want %d", fake.logins, tt.logins)
+			}
+		})
+	}
+}
+
+func TestRollbackOutlivesCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var ran []int
+	var undo rollback
+	for i := range 2 {
+		undo.add(func(ctx context.Context) error {
+			if ctx.Err() != nil {
+				t.Errorf("step %d got an ended context: %v", i, ctx.Err())
+			}
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("step %d got a context without deadline", i)
+			}
+			ran = append(ran, i)
+			return nil
+		})
+	}
+	undo.run(ctx)
+	if len(ran) != 2 || ran[0] != 1 || ran[1] != 0 {
+		t.Errorf("ran steps %v, want [1 0]", ran)
+	}
+}
@@ -0,0 +1,65 @@
+package pihole
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Errors of failed requests, to be matched with errors.Is.
+var (
+	// ErrNotFound means the group, client or domain does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAuth means Pi-hole rejected the password or the session.
+	ErrAuth = errors.New("authentication failed")
+	// ErrForbidden means the session is valid but may not make the change,
+	// e.g. an application password while webserver.api.app_sudo is off.
+	ErrForbidden = errors.New("permission denied")
+	// ErrConflict means the item exists already, e.g. because it was created
+	// at the same time in the Pi-hole web interface.
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable means Pi-hole did not answer several requests in a row,
+	// so requests are not sent for a while.
+	ErrUnavailable = errors.New("Pi-hole is unavailable")
+)
+
+// APIError is a request Pi-hole answered with an error status.
+type APIError struct {
+	Op     string
+	Status int
+	Body   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s failed: %d, body: %s", e.Op, e.Status, e.Body)
+}
+
+// Unwrap returns ErrAuth, ErrForbidden, ErrNotFound or ErrConflict if the
+// status tells.
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.Status == http.StatusUnauthorized:
+		return ErrAuth
+	case e.Status == http.StatusForbidden:
+		return ErrForbidden
+	case e.Status == http.StatusNotFound:
+		return ErrNotFound
+	case e.Status == http.StatusConflict || strings.Contains(e.Body, "UNIQUE constraint failed"):
+		// Pi-hole answers 400 when an item exists already
+		return ErrConflict
+	}
+	return nil
+}
+
+// apiError returns the error of a failed request. A rejected session is
+// dropped, so the next request authenticates again.
+func (c *Client) apiError(op string, resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	err := &APIError{Op: op, Status: resp.StatusCode, Body: string(body)}
+	if errors.Is(err, ErrAuth) {
+		c.sessionID = ""
+	}
+	return err
+}
@@ -1,5 +1,10 @@
 package pihole
 
+import (
+	"bytes"
+	"net"
+)
+
 type AuthResponse struct {
 	Session struct {
 		Valid    bool   `json:"valid"`
@@ -19,6 +24,11 @@ type QueryStats struct {
 	RecordsFiltered int     `json:"recordsFiltered"`
 	Draw            int     `json:"draw"`
 	Took            float64 `json:"took"`
+
+	// Set by GetDomainStats: the number of pages read and whether it
+	// read only the oldest queries because of MaxQueryPages.
+	Pages     int  `json:"-"`
+	Truncated bool `json:"-"`
 }
 
 type Query struct {
@@ -36,6 +46,22 @@ type Query struct {
 	CNAME    *string     `json:"cname"`
 }
 
+// Blocked reports whether Pi-hole blocked the query.
+func (q Query) Blocked() bool {
+	switch q.Status {
+	case "GRAVITY", "REGEX", "DENYLIST", "GRAVITY_CNAME", "REGEX_CNAME", "DENYLIST_CNAME",
+		"EXTERNAL_BLOCKED_IP", "EXTERNAL_BLOCKED_NULL", "EXTERNAL_BLOCKED_NXRA", "EXTERNAL_BLOCKED_EDE15",
+		"SPECIAL_DOMAIN", "DBBUSY":
+		return true
+	}
+	return false
+}
+
+// Cached reports whether Pi-hole answered the query from its cache.
+func (q Query) Cached() bool {
+	return q.Status == "CACHE" || q.Status == "CACHE_STALE"
+}
+
 type QueryReply struct {
 	Type string  `json:"type"`
 	Time float64 `json:"time"`
@@ -63,7 +89,7 @@ type Group struct {
 
 type GroupResponse struct {
 	Groups []Group `json:"groups"`
-	Took   float64 `json:"took"	`
+	Took   float64 `json:"took"`
 }
 
 type GroupListResponse struct {
@@ -79,7 +105,43 @@ type ClientItem struct {
 type DomainItem struct {
 	ID      int    `json:"id"`
 	Domain  string `json:"domain"`
-	Type    int    `json:"type"` // 0: whitelist exact, 1: blacklist exact, 2: whitelist regex, 3: blacklist regex
+	Type    string `json:"type"` // allow or deny
+	Kind    string `json:"kind"` // exact or regex
 	Comment string `json:"comment"`
 	Groups  []int  `json:"groups"`
+	Enabled bool   `json:"enabled"`
+}
+
+type DomainListResponse struct {
+	Domains []DomainItem `json:"domains"`
+}
+
+// Device is an entry of the Pi-hole network table.
+type Device struct {
+	ID        int        `json:"id"`
+	HWAddr    string     `json:"hwaddr"`
+	Interface string     `json:"interface"`
+	MacVendor string     `json:"macVendor"`
+	IPs       []DeviceIP `json:"ips"`
+}
+
+// MAC returns the MAC address of the device. Pi-hole lists devices it knows
+// no MAC address for with a placeholder such as "ip-192.168.1.15", MAC
+// returns an empty string for them.
+func (d Device) MAC() string {
+	mac, err := net.ParseMAC(d.HWAddr)
+	if err != nil || bytes.Equal(mac, make([]byte, len(mac))) {
+		return ""
+	}
+	return mac.String()
+}
+
+type DeviceIP struct {
+	IP       string  `json:"ip"`
+	Name     *string `json:"name"`
+	LastSeen int64   `json:"lastSeen"`
+}
+
+type DeviceResponse struct {
+	Devices []Device `json:"devices"`
 }
@@ -0,0 +1,288 @@
+package pihole
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+)
+
+// RepairBlocks makes the block groups in Pi-hole match the blocks the app
+// wants, given as the Pi-hole clients to block per service. It undoes changes
+// made in the Pi-hole web interface, e.g. a client removed from a block group
+// or a disabled group, and completes blocks that failed halfway. Only what
+// differs is changed and every drift is logged. It returns the number of
+// drifts fixed.
+func (c *Client) RepairBlocks(ctx context.Context, services []config.Service, blocked map[string][]string) (int, error) {
+	if err := c.Auth(ctx); err != nil {
+		return 0, err
+	}
+
+	groups, err := c.listGroups(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list groups: %w", err)
+	}
+	clients, err := c.listClients(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list clients: %w", err)
+	}
+	rules, err := c.listDomains(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list domains: %w", err)
+	}
+
+	fixed := 0
+	var errs []error
+	repair := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		fixed++
+	}
+
+	for _, service := range services {
+		want := blocked[service.Name]
+		name := groupName(service.Name)
+		i := slices.IndexFunc(groups, func(g Group) bool { return g.Name == name })
+		var group Group
+		switch {
+		case i < 0 && len(want) == 0:
+			// Nothing was blocked yet
+			continue
+		case i < 0:
+			fmt.Printf("Drift: block group %s is missing. Creating it...\n", name)
+			id, _, err := c.getOrCreateGroup(ctx, name)
+			repair(err)
+			if err != nil {
+				continue
+			}
+			group = Group{ID: id, Name: name, Enabled: true}
+		default:
+			group = groups[i]
+			if !group.Enabled {
+				fmt.Printf("Drift: block group %s is disabled. Enabling it...\n", name)
+				group.Enabled = true
+				repair(c.updateGroup(ctx, group))
+			}
+		}
+
+		for _, pattern := range service.Patterns {
+			rule := findRule(rules, pattern)
+			if ruleInGroup(rule, group.ID) {
+				continue
+			}
+			if rule == nil {
+				fmt.Printf("Drift: domain %s is missing. Adding it to group %s...\n", pattern, name)
+			} else {
+				fmt.Printf("Drift: domain %s is disabled or not in group %s. Adding it...\n", pattern, name)
+			}
+			repair(c.assignDomain(ctx, rule, pattern, group.ID, nil))
+		}
+
+		// Domains added to the group in Pi-hole, or left over from patterns
+		// removed from the config while the service was down
+		for i, rule := range rules {
+			if !slices.Contains(rule.Groups, group.ID) || serviceHasRule(service, rule) {
+				continue
+			}
+			fmt.Printf("Drift: domain %s is in group %s, but not part of %s. Removing it from the group...\n", rule.Domain, name, service.Name)
+			update := rule
+			update.Groups = slices.DeleteFunc(slices.Clone(rule.Groups), func(gid int) bool { return gid == group.ID })
+			err := c.updateDomain(ctx, update)
+			repair(err)
+			if err == nil {
+				rules[i] = update
+			}
+		}
+
+		for _, client := range clients {
+			if slices.Contains(client.Groups, group.ID) && !slices.Contains(want, client.IP) {
+				fmt.Printf("Drift: client %s is in block group %s, but is not blocked for %s. Removing it...\n", client.IP, name, service.Name)
+				repair(c.removeClientFromGroup(ctx, client.IP, group.ID))
+			}
+		}
+		for _, key := range want {
+			member := slices.ContainsFunc(clients, func(client ClientItem) bool {
+				return client.IP == key && slices.Contains(client.Groups, group.ID)
+			})
+			if !member {
+				fmt.Printf("Drift: client %s is not in block group %s. Adding it...\n", key, name)
+				repair(c.addClientToGroup(ctx, key, group.ID))
+			}
+		}
+	}
+	return fixed, errors.Join(errs...)
+}
+
+// MigrateGroups collapses the block groups of older versions, one per client
+// and service ("ParentalControl-youtube-192.168.1.15") or one per client of
+// the first versions ("ParentalControl-192.168.1.15"), into the block group
+// of the service: their members join it and the old groups are deleted. It
+// returns the number of groups migrated.
+func (c *Client) MigrateGroups(ctx context.Context, services []config.Service) (int, error) {
+	if err := c.Auth(ctx); err != nil {
+		return 0, err
+	}
+
+	groups, err := c.listGroups(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list groups: %w", err)
+	}
+	legacy := map[string][]Group{}
+	for _, group := range groups {
+		if service := legacyService(group.Name, services); service != "" {
+			legacy[service] = append(legacy[service], group)
+		}
+	}
+	if len(legacy) == 0 {
+		return 0, nil
+	}
+	clients, err := c.listClients(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list clients: %w", err)
+	}
+
+	migrated := 0
+	for _, service := range services {
+		if len(legacy[service.Name]) == 0 {
+			continue
+		}
+		var undo rollback
+		groupID, err := c.serviceGroup(ctx, service.Name, service.Patterns, &undo)
+		if err != nil {
+			undo.run(ctx)
+			return migrated, err
+		}
+		old := map[int]bool{}
+		for _, group := range legacy[service.Name] {
+			fmt.Printf("Migrating group %s to %s...\n", group.Name, groupName(service.Name))
+			old[group.ID] = true
+		}
+
+		for i := range clients {
+			client := &clients[i]
+			if !slices.ContainsFunc(client.Groups, func(gid int) bool { return old[gid] }) {
+				continue
+			}
+			update := *client
+			update.Groups = slices.DeleteFunc(slices.Clone(client.Groups), func(gid int) bool { return old[gid] })
+			if !slices.Contains(update.Groups, groupID) {
+				update.Groups = append(update.Groups, groupID)
+			}
+			if err := c.updateClient(ctx, &update); err != nil {
+				return migrated, fmt.Errorf("failed to move client %s: %w", client.IP, err)
+			}
+			*client = update
+		}
+
+		// Pi-hole drops the old groups from the deny rules itself
+		for _, group := range legacy[service.Name] {
+			if err := c.delete(ctx, "/api/groups/"+url.PathEscape(group.Name)); err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+	}
+	return migrated, nil
+}
+
+// legacyService returns the service of a block group of an older version, or
+// an empty string for other groups. The per-client groups are named
+// "ParentalControl-<service>-<client>", where the longest service name wins,
+// so the groups of a service "tv" are not mistaken for the groups of
+// "tv-kids". The groups of the first versions, "ParentalControl-<ip>", belong
+// to youtube.
+func legacyService(group string, services []config.Service) string {
+	name := ""
+	for _, service := range services {
+		if group == groupName(service.Name) {
+			// The current group of a service
+			return ""
+		}
+		client, ok := strings.CutPrefix(group, groupName(service.Name)+"-")
+		if ok && config.ValidateClient(client) == nil && len(service.Name) > len(name) {
+			name = service.Name
+		}
+	}
+	if name == "" && firstVersionGroup(group) && slices.ContainsFunc(services, func(service config.Service) bool { return service.Name == "youtube" }) {
+		return "youtube"
+	}
+	return name
+}
+
+// serviceHasRule reports whether the deny rule is one of the service patterns.
+func serviceHasRule(service config.Service, rule DomainItem) bool {
+	for _, pattern := range service.Patterns {
+		if kind, value := denyRule(pattern); rule.Kind == kind && rule.Domain == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) listDomains(ctx context.Context) ([]DomainItem, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", c.config.PiholeAddress+"/api/domains/deny", nil)
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.apiError("list domains", resp)
+	}
+
+	var domains DomainListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&domains); err != nil {
+		return nil, err
+	}
+	return domains.Domains, nil
+}
+
+// updateDomain replaces the groups and the enabled flag of a deny rule.
+func (c *Client) updateDomain(ctx context.Context, rule DomainItem) error {
+	data, _ := json.Marshal(map[string]interface{}{
+		"comment": rule.Comment,
+		"groups":  rule.Groups,
+		"enabled": rule.Enabled,
+	})
+	req, _ := http.NewRequestWithContext(ctx, "PUT", c.config.PiholeAddress+"/api/domains/deny/"+rule.Kind+"/"+url.PathEscape(rule.Domain), bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return c.apiError("update domain", resp)
+	}
+	return nil
+}
+
+func (c *Client) updateGroup(ctx context.Context, group Group) error {
+	data, _ := json.Marshal(map[string]interface{}{
+		"name":    group.Name,
+		"comment": group.Comment,
+		"enabled": group.Enabled,
+	})
+	req, _ := http.NewRequestWithContext(ctx, "PUT", c.config.PiholeAddress+"/api/groups/"+url.PathEscape(group.Name), bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return c.apiError("update group", resp)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package pihole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	// breakerThreshold is the number of requests failing in a row after
+	// which Pi-hole counts as down.
+	breakerThreshold = 3
+	// breakerCooldown is how long requests fail at once while Pi-hole is
+	// down, before the next request tries again.
+	breakerCooldown = time.Minute
+	maxBackoff      = 30 * time.Second
+)
+
+// breaker stops sending requests to a Pi-hole that does not answer, so checks
+// fail fast instead of waiting for every request to time out.
+type breaker struct {
+	failures  int
+	lastErr   error
+	openUntil time.Time
+}
+
+// allow returns ErrUnavailable while Pi-hole is down and the cooldown lasts.
+// Once it is over, a single request is let through to try again.
+func (b *breaker) allow() error {
+	if b.failures < breakerThreshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) {
+		return fmt.Errorf("%w: %v", ErrUnavailable, b.lastErr)
+	}
+	b.openUntil = time.Now().Add(breakerCooldown)
+	return nil
+}
+
+func (b *breaker) succeeded() {
+	b.failures = 0
+	b.lastErr = nil
+}
+
+func (b *breaker) failed(err error) {
+	b.failures++
+	b.lastErr = err
+	if b.failures >= breakerThreshold {
+		b.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
+
+// Down returns why Pi-hole counts as down, or nil while it answers.
+func (c *Client) Down() error {
+	if c.breaker.failures < breakerThreshold {
+		return nil
+	}
+	return c.breaker.lastErr
+}
+
+// stopped returns the error of a request whose context ended while Pi-hole
+// failed to answer. Cancellation is not Pi-hole's fault, a deadline that
+// passed while it did not answer counts towards the breaker.
+func (c *Client) stopped(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.breaker.failed(ctx.Err())
+	}
+	return ctx.Err()
+}
+
+// send sends a request. Requests that are safe to repeat are retried when
+// Pi-hole cannot be reached or answers with a server error, waiting longer
+// before every retry. A request that fails for good counts towards the
+// breaker, the response of the last attempt is returned then.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	ctx := req.Context()
+	if ctx.Err() != nil {
+		// Never sent, so it says nothing about Pi-hole
+		return nil, ctx.Err()
+	}
+	retries := 0
+	if idempotent(req.Method) {
+		retries = c.config.Retries
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.client.Do(req)
+		if err == nil && !retryable(resp.StatusCode) {
+			c.breaker.succeeded()
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, c.stopped(ctx)
+		}
+		if attempt >= retries {
+			if err != nil {
+				c.breaker.failed(err)
+				return nil, err
+			}
+			c.breaker.failed(fmt.Errorf("%s %s: status %d", req.Method, req.URL.Path, resp.StatusCode))
+			return resp, nil
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		delay := backoff(c.config.RetryBackoff, attempt)
+		fmt.Printf("Pi-hole request %s %s failed, retrying in %v...\n", req.Method, req.URL.Path, delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, c.stopped(ctx)
+		}
+
+		retry := req.Clone(ctx)
+		if req.GetBody != nil {
+			if retry.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		req = retry
+	}
+}
+
+// idempotent reports whether repeating a request does no harm. Pi-hole
+// creates items with POST, so those are not repeated.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func retryable(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// backoff doubles the delay with every attempt, up to maxBackoff. A random
+// part spreads retries of several clients.
+func backoff(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 0; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxBackoff)
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half)
+}
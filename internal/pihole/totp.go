@@ -0,0 +1,53 @@
+package pihole
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// totpPeriod is the time step of the codes in the Pi-hole authenticator app.
+const totpPeriod = 30 * time.Second
+
+// totpCode returns the 6 digit code (RFC 6238) of the base32 secret for the
+// time step of t.
+func totpCode(secret string, t time.Time) (int, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return 0, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(totpPeriod.Seconds())))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return int((binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff) % 1000000), nil
+}
+
+// nextTOTP returns the code for the next login. Pi-hole rejects a code that
+// was used already, so a second login within the same time step waits for
+// the next one.
+func (c *Client) nextTOTP(ctx context.Context) (int, error) {
+	now := time.Now()
+	step := now.Truncate(totpPeriod)
+	if !step.After(c.lastTOTP) {
+		step = c.lastTOTP.Add(totpPeriod)
+		select {
+		case <-time.After(step.Sub(now)):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	code, err := totpCode(c.config.TOTPSecret, step)
+	if err != nil {
+		return 0, err
+	}
+	c.lastTOTP = step
+	return code, nil
+}
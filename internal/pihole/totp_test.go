@@ -0,0 +1,37 @@
+package pihole
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// The SHA-1 test vectors of RFC 6238, secret "12345678901234567890"
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tests := []struct {
+		unix int64
+		want int
+	}{
+		{59, 287082},
+		{1111111109, 81804},
+		{1111111111, 50471},
+		{1234567890, 5924},
+		{2000000000, 279037},
+		{20000000000, 353130},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("totpCode at %d failed: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode at %d = %06d, want %06d", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := totpCode("not base32!", time.Unix(59, 0)); err == nil {
+		t.Error("totpCode with an invalid secret succeeded")
+	}
+}
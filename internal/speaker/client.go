@@ -23,6 +23,11 @@ type SpeakRequest struct {
 	Language string `json:"language"`
 }
 
+// SetConfig swaps the config on reload.
+func (c *Client) SetConfig(cfg config.Config) {
+	c.cfg = cfg
+}
+
 func (c *Client) Speak(message string) error {
 	if c.cfg.SpeakerURL == "" {
 		return nil // Speaker not configured
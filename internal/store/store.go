@@ -0,0 +1,37 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vladikamira/pihole-parental-control/internal/config"
+)
+
+// ErrNotFound is returned by Load when nothing has been saved yet.
+var ErrNotFound = errors.New("state not found")
+
+// Store persists application state between restarts.
+type Store interface {
+	Load(v any) error
+	Save(v any) error
+	Close() error
+}
+
+func New(cfg config.Config) (Store, error) {
+	switch cfg.StateBackend {
+	case "json":
+		return NewJSONStore(cfg.StatePath), nil
+	case "sqlite":
+		return NewSQLiteStore(cfg.StatePath)
+	case "none":
+		return nopStore{}, nil
+	default:
+		return nil, fmt.Errorf("unknown state backend: %q", cfg.StateBackend)
+	}
+}
+
+type nopStore struct{}
+
+func (nopStore) Load(v any) error { return ErrNotFound }
+func (nopStore) Save(v any) error { return nil }
+func (nopStore) Close() error     { return nil }
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testState struct {
+	Day     string         `json:"day"`
+	Clients map[string]int `json:"clients"`
+}
+
+// testStore checks a store that has nothing saved yet: Load reports
+// ErrNotFound, and every Save replaces what the previous one saved.
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	var got testState
+	if err := s.Load(&got); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Load of an empty store = %v, want ErrNotFound", err)
+	}
+
+	for _, want := range []testState{
+		{Day: "2026-10-16", Clients: map[string]int{"192.168.1.15": 3, "tablet": 1}},
+		{Day: "2026-10-17", Clients: map[string]int{"tablet": 2}},
+	} {
+		if err := s.Save(want); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		got = testState{}
+		if err := s.Load(&got); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Load = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestJSONStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data", "stats.json")
+	testStore(t, NewJSONStore(path))
+
+	// The temp files of the atomic writes are gone
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "stats.json" {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("files after saving = %v, want [stats.json]", names)
+	}
+}
+
+func TestJSONStoreEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	if err := os.WriteFile(path, []byte("\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, NewJSONStore(path))
+}
+
+func TestJSONStoreCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	if err := os.WriteFile(path, []byte(`{"day": "2026-10`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var got testState
+	if err := NewJSONStore(path).Load(&got); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Load of a truncated file = %v, want a decode error", err)
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "stats.db")
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The state survives reopening the database
+	s, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var got testState
+	if err := s.Load(&got); err != nil {
+		t.Fatalf("Load after reopening failed: %v", err)
+	}
+	if got.Day != "2026-10-17" {
+		t.Errorf("Load after reopening = %+v, want the last saved state", got)
+	}
+	var rows int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM state`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Errorf("state table has %d rows, want 1", rows)
+	}
+}
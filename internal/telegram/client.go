@@ -24,6 +24,11 @@ func NewClient(cfg config.Config) *Client {
 	}
 }
 
+// SetConfig swaps the config on reload.
+func (c *Client) SetConfig(cfg config.Config) {
+	c.config = cfg
+}
+
 func (c *Client) SendMessage(message string) error {
 	if c.config.TelegramToken == "" || c.config.TelegramChatID == "" {
 		return fmt.Errorf("telegram token or chat id is empty")